@@ -15,23 +15,20 @@ func main() {
 	// not recommended, but if you must, uncomment this to
 	// restrict prerendering to bots and the _escaped_fragment_ query param
 	// prerenderCloudOptions.BotsOnly = true
-	// prerendercloud.CrawlerUserAgents = append(prerendercloud.CrawlerUserAgents, "googlebot")
+	// prerenderCloudOptions.AddBots("googlebot")
 
 	prerenderCloud := prerenderCloudOptions.NewPrerender()
 
 	requestHandler := func(ctx *fasthttp.RequestCtx) {
-		if prerenderCloud.ShouldPrerenderFastHttp(ctx) && prerenderCloud.PreRenderHandlerFastHttp(ctx) == nil {
-			return
-		} else {
-			ctx.SetContentType("text/html")
-			fmt.Fprintf(ctx, `
+		ctx.SetContentType("text/html")
+		fmt.Fprintf(ctx, `
         <div id='root'></div>
         <script type='text/javascript'>
           document.getElementById('root').innerHTML = "hello";
         </script>
       `)
-		}
 	}
 
-	fasthttp.ListenAndServe(":8080", requestHandler)
+	// requests that aren't prerendered, or whose render fails, reach requestHandler
+	fasthttp.ListenAndServe(":8080", prerenderCloud.FastHTTPMiddleware(requestHandler))
 }
@@ -0,0 +1,135 @@
+package prerendercloud
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// userAgent identifies the middleware to the service.
+const userAgent = "prerender-cloud-golang-middleware"
+
+// maxErrorMessage bounds how much of an error response body is kept in an
+// APIError or UpstreamError.
+const maxErrorMessage = 512
+
+// httpDoer sends a single request: an *http.Client, or an httpUpstream
+// choosing the client for each attempt.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// send performs req with the headers every request to the service carries,
+// retrying according to Options.Retry, all bounded by Options.Timeout. The
+// timeout lasts until the response body is closed. The outcome is recorded by
+// Options.CircuitBreaker, which fails the request straight away while open.
+func (p *Prerender) send(client httpDoer, req *http.Request) (*http.Response, error) {
+	p.setServiceHeaders(req.Header)
+
+	ctx, cancel, record, err := p.begin(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	res, err := p.do(client, req)
+	if err != nil {
+		cancel()
+		perr := transportError(err, NetworkError)
+		record(0, perr)
+		return nil, perr
+	}
+	record(res.StatusCode, nil)
+
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// setServiceHeaders sets the headers every request to the service carries.
+func (p *Prerender) setServiceHeaders(header headerSetter) {
+	header.Set("User-Agent", userAgent)
+
+	if p.Options.Token != "" {
+		header.Set("X-Prerender-Token", p.Options.Token)
+	}
+}
+
+// begin starts a request to the service made within ctx. It bounds ctx by
+// Options.Timeout and asks Options.CircuitBreaker for permission; record
+// reports the outcome to it, and cancel must be called once the response
+// has been read. On error nothing needs to be called.
+func (p *Prerender) begin(ctx context.Context) (_ context.Context, cancel context.CancelFunc, record func(status int, err *Error), err error) {
+	cancel = func() {}
+	if p.Options.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.Options.Timeout)
+	}
+
+	if record, err = p.admit(); err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return ctx, cancel, record, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// newAPIRequest builds a request to one of the service's API endpoints, such
+// as "screenshot", for targetURL.
+func (p *Prerender) newAPIRequest(ctx context.Context, endpoint, targetURL string) (*http.Request, error) {
+	if p.Options.PrerenderURL == nil {
+		return nil, &Error{Kind: RequestError, Err: errors.New("PrerenderURL is required")}
+	}
+
+	if !strings.HasPrefix(targetURL, "http://") && !strings.HasPrefix(targetURL, "https://") {
+		return nil, &Error{Kind: RequestError, Err: errors.New("url must be absolute http or https: " + targetURL)}
+	}
+
+	base := p.Options.PrerenderURL.String()
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", base+endpoint+"/"+targetURL, nil)
+	if err != nil {
+		return nil, &Error{Kind: RequestError, Err: err}
+	}
+	return req, nil
+}
+
+// doAPI sends an API request and returns the response when the service
+// accepted it. Any other response is closed and turned into an *Error.
+func (p *Prerender) doAPI(req *http.Request) (*http.Response, error) {
+	res, err := p.send(p.httpClient(nil), req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	message, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorMessage))
+	kind := APIError
+	if res.StatusCode >= 500 {
+		kind = UpstreamError
+	}
+
+	return nil, &Error{
+		Kind:       kind,
+		StatusCode: res.StatusCode,
+		Err:        errors.New(strings.TrimSpace(string(message))),
+	}
+}
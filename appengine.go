@@ -0,0 +1,14 @@
+package prerendercloud
+
+import (
+	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// appEngineClient is the ClientFactory installed for the deprecated
+// UsingAppEngine option.
+func appEngineClient(r *http.Request) *http.Client {
+	return urlfetch.Client(appengine.NewContext(r))
+}
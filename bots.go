@@ -0,0 +1,122 @@
+package prerendercloud
+
+import (
+	"regexp"
+	"strings"
+)
+
+// BotMatcher recognizes crawler user agents. Substrings are matched
+// case-insensitively anywhere in the user agent; regular expressions are
+// matched as given. A BotMatcher is immutable and safe for concurrent use.
+type BotMatcher struct {
+	substrings []string
+	regexps    []*regexp.Regexp
+}
+
+// NewBotMatcher returns a BotMatcher matching user agents that contain any of
+// substrings, ignoring case.
+func NewBotMatcher(substrings ...string) *BotMatcher {
+	m := &BotMatcher{}
+	for _, s := range substrings {
+		if s = strings.ToLower(s); s != "" {
+			m.substrings = append(m.substrings, s)
+		}
+	}
+	return m
+}
+
+// WithRegexps returns a copy of m that also matches user agents matching any
+// of regexps.
+func (m *BotMatcher) WithRegexps(regexps ...*regexp.Regexp) *BotMatcher {
+	return &BotMatcher{
+		substrings: m.substrings,
+		regexps:    append(append([]*regexp.Regexp(nil), m.regexps...), regexps...),
+	}
+}
+
+// With returns a copy of m that also matches substrings.
+func (m *BotMatcher) With(substrings ...string) *BotMatcher {
+	added := NewBotMatcher(substrings...)
+	return &BotMatcher{
+		substrings: append(append([]string(nil), m.substrings...), added.substrings...),
+		regexps:    m.regexps,
+	}
+}
+
+// Without returns a copy of m that no longer matches substrings, ignoring
+// case. Regular expressions are kept.
+func (m *BotMatcher) Without(substrings ...string) *BotMatcher {
+	removed := NewBotMatcher(substrings...)
+	kept := &BotMatcher{regexps: m.regexps}
+
+	for _, s := range m.substrings {
+		if !containsString(removed.substrings, s) {
+			kept.substrings = append(kept.substrings, s)
+		}
+	}
+
+	return kept
+}
+
+// Substrings returns the lowercased substrings m matches.
+func (m *BotMatcher) Substrings() []string {
+	return append([]string(nil), m.substrings...)
+}
+
+// Match reports whether userAgent belongs to a crawler.
+func (m *BotMatcher) Match(userAgent string) bool {
+	lower := strings.ToLower(userAgent)
+	for _, s := range m.substrings {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+
+	for _, re := range m.regexps {
+		if re.MatchString(userAgent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bots returns the matcher used when BotsOnly is set: Options.Bots, or
+// CrawlerUserAgents as it was when the Prerender was created.
+func (p *Prerender) bots() *BotMatcher {
+	if p.Options.Bots != nil {
+		return p.Options.Bots
+	}
+	if p.defaultBots != nil {
+		return p.defaultBots
+	}
+	return NewBotMatcher(CrawlerUserAgents...)
+}
+
+// AddBots makes BotsOnly also prerender for user agents containing any of
+// substrings, for example AddBots(SearchEngineBots...). Like every Options
+// field, call it before serving requests.
+func (o *Options) AddBots(substrings ...string) {
+	o.Bots = o.botMatcher().With(substrings...)
+}
+
+// RemoveBots stops BotsOnly from prerendering for the given substrings.
+func (o *Options) RemoveBots(substrings ...string) {
+	o.Bots = o.botMatcher().Without(substrings...)
+}
+
+func (o *Options) botMatcher() *BotMatcher {
+	if o.Bots != nil {
+		return o.Bots
+	}
+	return NewBotMatcher(CrawlerUserAgents...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package prerendercloud
+
+import (
+	"regexp"
+	"testing"
+)
+
+func Test_BotMatcherDefaultCrawlers(t *testing.T) {
+	bots := NewBotMatcher(CrawlerUserAgents...)
+
+	tests := []struct {
+		userAgent string
+		bot       bool
+	}{
+		{"Twitterbot/1.0", true},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true},
+		{"WhatsApp/2.19.81 A", true},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_1) AppleWebKit/600.2.5 (KHTML, like Gecko) Version/8.0.2 Safari/600.2.5 (Applebot/0.1; +http://www.apple.com/go/applebot)", true},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", true},
+		{"LinkedInBot/1.0 (compatible; Mozilla/5.0; Apache-HttpClient +http://www.linkedin.com)", true},
+		{"Mozilla/5.0 (compatible; vkShare; +http://vk.com/dev/Share)", true},
+		{"Pinterest/0.2 (+https://www.pinterest.com/bot.html)", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", false},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", false},
+		// googlebot is left out of the defaults on purpose
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if bots.Match(test.userAgent) != test.bot {
+			t.Errorf("Match(%q) should be %v", test.userAgent, test.bot)
+		}
+	}
+}
+
+func Test_BotMatcherWithRegexps(t *testing.T) {
+	base := NewBotMatcher("slackbot")
+	bots := base.WithRegexps(regexp.MustCompile(`^Mozilla/5\.0 \(compatible; [A-Za-z]+bot/`))
+
+	if !bots.Match("Mozilla/5.0 (compatible; Bingbot/2.0; +http://www.bing.com/bingbot.htm)") {
+		t.Error("regexp should match")
+	}
+
+	if !bots.Match("Slackbot 1.0") {
+		t.Error("substring should still match")
+	}
+
+	if base.Match("Mozilla/5.0 (compatible; Bingbot/2.0; +http://www.bing.com/bingbot.htm)") {
+		t.Error("WithRegexps should not modify the original matcher")
+	}
+}
+
+func Test_ShouldPrerenderWithCaseSensitiveRegexp(t *testing.T) {
+	options := NewOptions()
+	options.BotsOnly = true
+	options.Bots = NewBotMatcher().WithRegexps(regexp.MustCompile(`^Mozilla/5\.0 \(compatible; [A-Z][a-z]+bot/`))
+	p := options.NewPrerender()
+
+	c := conformanceCase{method: "GET", url: "http://example.org/", headers: map[string]string{"User-Agent": "Mozilla/5.0 (compatible; Bingbot/2.0)"}}
+	if !p.ShouldPrerender(c.httpRequest(t).(httpRequest).r) {
+		t.Error("a case-sensitive regexp should match the user agent as sent")
+	}
+	if !p.ShouldPrerenderFastHttp(c.fasthttpRequest(t).(fasthttpRequest).ctx) {
+		t.Error("a case-sensitive regexp should match the user agent as sent through fasthttp")
+	}
+
+	c.headers["User-Agent"] = "mozilla/5.0 (compatible; bingbot/2.0)"
+	if p.ShouldPrerender(c.httpRequest(t).(httpRequest).r) {
+		t.Error("a case-sensitive regexp should not match a differently cased user agent")
+	}
+}
+
+func Test_BotsOnlyUsesInstanceMatcher(t *testing.T) {
+	options := NewOptions()
+	options.BotsOnly = true
+	options.Bots = NewBotMatcher("googlebot")
+	p := options.NewPrerender()
+
+	if !p.shouldPrerender(conformanceCase{method: "GET", url: "http://example.org/", headers: map[string]string{"User-Agent": "Mozilla/5.0 (compatible; Googlebot/2.1)"}}.httpRequest(t)) {
+		t.Error("Options.Bots should be used when BotsOnly is set")
+	}
+
+	if p.shouldPrerender(conformanceCase{method: "GET", url: "http://example.org/", headers: map[string]string{"User-Agent": "Twitterbot/1.0"}}.httpRequest(t)) {
+		t.Error("Options.Bots should replace CrawlerUserAgents")
+	}
+
+	if !NewOptions().NewPrerender().bots().Match("Twitterbot/1.0") {
+		t.Error("CrawlerUserAgents should be used when Options.Bots is nil")
+	}
+}
+
+func Test_OptionsAddAndRemoveBots(t *testing.T) {
+	options := NewOptions()
+	options.AddBots(SearchEngineBots...)
+	options.RemoveBots("Twitterbot")
+
+	if !options.Bots.Match("Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)") {
+		t.Error("added preset should match")
+	}
+
+	if options.Bots.Match("Twitterbot/1.0") {
+		t.Error("removed bot should not match")
+	}
+
+	if !NewOptions().NewPrerender().bots().Match("Twitterbot/1.0") {
+		t.Error("changing one Options should not affect others")
+	}
+}
+
+func Test_CrawlerUserAgentsAddedAfterNewOptions(t *testing.T) {
+	defer func(original []string) { CrawlerUserAgents = original }(CrawlerUserAgents)
+
+	options := NewOptions()
+	CrawlerUserAgents = append(CrawlerUserAgents, "mycrawler")
+	p := options.NewPrerender()
+
+	if !p.bots().Match("MyCrawler/1.0") {
+		t.Error("Error, user agents added to CrawlerUserAgents before NewPrerender should be used")
+	}
+}
+
+func Test_BotPresets(t *testing.T) {
+	tests := []struct {
+		preset    []string
+		userAgent string
+	}{
+		{SocialPreviewBots, "TelegramBot (like TwitterBot)"},
+		{SearchEngineBots, "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"},
+		{SEOToolBots, "Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)"},
+		{AICrawlerBots, "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)"},
+		{AICrawlerBots, "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; ClaudeBot/1.0; +claudebot@anthropic.com)"},
+	}
+
+	for _, test := range tests {
+		if !NewBotMatcher(test.preset...).Match(test.userAgent) {
+			t.Errorf("preset should match %q", test.userAgent)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package prerendercloud
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a prerendered response, read in full and decompressed.
+// Responses handed to or returned from a Cache must not be modified.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// Expires is when the response stops being fresh. A zero Expires lets the
+	// Cache apply its own TTL.
+	Expires time.Time
+
+	// Stale is how long past Expires the response may still be served while
+	// it is revalidated or while the service is failing.
+	Stale time.Duration
+}
+
+// Cache stores prerendered responses keyed on the upstream Prerender.cloud
+// URL, plus a hash of any RenderOptions.OriginHeaderWhitelist values.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the response stored for key, if any. Responses past their
+	// Expires should still be returned until Expires+Stale.
+	Get(key string) (*CachedResponse, bool)
+	// Set stores res under key.
+	Set(key string, res *CachedResponse)
+}
+
+// DefaultRefreshWorkers is the number of concurrent background refreshes used
+// when Options.RefreshWorkers is zero.
+const DefaultRefreshWorkers = 4
+
+// render serves u from Options.Cache when possible, and otherwise fetches it
+// and stores cacheable responses. Stale responses are served while they are
+// refreshed in the background (Options.StaleWhileRevalidate) or when the
+// service fails (Options.StaleIfError). A non-zero ttl overrides the upstream
+// Cache-Control lifetime.
+func (p *Prerender) render(u upstream, ttl time.Duration) (*CachedResponse, error) {
+	cache := p.Options.Cache
+	if cache == nil {
+		return p.coalesce(u, upstream.fetch)
+	}
+
+	key := u.key()
+	cached, ok := cache.Get(key)
+	now := time.Now()
+
+	if ok && (cached.Expires.IsZero() || now.Before(cached.Expires)) {
+		return cached, nil
+	}
+
+	if ok && now.Before(cached.Expires.Add(p.Options.StaleWhileRevalidate)) {
+		p.refresh(u, key, ttl)
+		return cached, nil
+	}
+
+	res, err := p.coalesce(u, func(u upstream) (*CachedResponse, error) {
+		res, err := u.fetch()
+		if err == nil {
+			p.store(key, res, ttl)
+		}
+		return res, err
+	})
+	if err != nil && ok && !IsErrorKind(err, CanceledError) && now.Before(cached.Expires.Add(p.Options.StaleIfError)) {
+		return cached, nil
+	}
+
+	return res, err
+}
+
+func (p *Prerender) store(key string, res *CachedResponse, ttl time.Duration) {
+	if !cacheableStatus(res.StatusCode) {
+		return
+	}
+
+	now := time.Now()
+	expires, ok := cacheExpiry(res.Header, now)
+	if !ok {
+		return
+	}
+
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+
+	res.Expires = expires
+	res.Stale = p.Options.StaleWhileRevalidate
+	if p.Options.StaleIfError > res.Stale {
+		res.Stale = p.Options.StaleIfError
+	}
+
+	p.Options.Cache.Set(key, res)
+}
+
+// refresh re-fetches key in the background. At most Options.RefreshWorkers
+// refreshes run at once and each key is refreshed only once at a time; when
+// no worker is free the refresh is skipped and retried on a later request.
+func (p *Prerender) refresh(u upstream, key string, ttl time.Duration) {
+	p.refreshMu.Lock()
+	if p.refreshing == nil {
+		workers := p.Options.RefreshWorkers
+		if workers <= 0 {
+			workers = DefaultRefreshWorkers
+		}
+		p.refreshing = make(map[string]bool)
+		p.refreshSlots = make(chan struct{}, workers)
+	}
+
+	if p.refreshing[key] {
+		p.refreshMu.Unlock()
+		return
+	}
+
+	select {
+	case p.refreshSlots <- struct{}{}:
+	default:
+		p.refreshMu.Unlock()
+		return
+	}
+
+	p.refreshing[key] = true
+	p.refreshMu.Unlock()
+
+	// the incoming request is finished long before the refresh is, so the
+	// refresh can't share its context
+	u = u.detach(context.Background(), false)
+
+	go func() {
+		defer u.release()
+		defer func() {
+			p.refreshMu.Lock()
+			delete(p.refreshing, key)
+			<-p.refreshSlots
+			p.refreshMu.Unlock()
+		}()
+
+		if res, err := u.fetch(); err == nil {
+			p.store(key, res, ttl)
+		}
+	}()
+}
+
+// cacheableStatus reports whether a response with status code may be stored.
+// Only successful renders and answers that don't change from one request to
+// the next are kept; a rate limit or a rejected token must not be served from
+// the cache.
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// cacheExpiry applies the upstream Cache-Control header. It reports false when
+// the response must not be cached, and returns a zero time when the response
+// doesn't specify a lifetime.
+func cacheExpiry(header http.Header, now time.Time) (time.Time, bool) {
+	var expires time.Time
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value := strings.TrimSpace(strings.ToLower(directive)), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], strings.Trim(name[i+1:], `"`)
+		}
+
+		switch name {
+		case "no-store", "no-cache", "private":
+			return time.Time{}, false
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			if seconds <= 0 {
+				return time.Time{}, false
+			}
+			// s-maxage takes precedence over max-age for shared caches
+			if expires.IsZero() || name == "s-maxage" {
+				expires = now.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+
+	return expires, true
+}
+
+// LRUCache is an in-memory Cache bounded by the total size of the stored
+// responses, evicting the least recently used entries first.
+type LRUCache struct {
+	maxBytes int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	res  *CachedResponse
+	size int
+}
+
+// NewLRUCache creates an LRUCache holding up to maxBytes of responses. ttl is
+// how long responses without a Cache-Control max-age stay fresh.
+func NewLRUCache(maxBytes int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		now:      time.Now,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the response stored for key until it is past Expires+Stale.
+func (c *LRUCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if c.now().After(entry.res.Expires.Add(entry.res.Stale)) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.res, true
+}
+
+// Set stores res under key, evicting least recently used entries to stay
+// within the size bound. Responses larger than the bound are not stored.
+func (c *LRUCache) Set(key string, res *CachedResponse) {
+	stored := *res
+	if stored.Expires.IsZero() {
+		stored.Expires = c.now().Add(c.ttl)
+	}
+
+	entry := &lruEntry{key: key, res: &stored, size: responseSize(key, &stored)}
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	c.items[key] = c.ll.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Len returns the number of stored responses, including ones past their stale
+// window that haven't been evicted yet.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*lruEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+func responseSize(key string, res *CachedResponse) int {
+	size := len(key) + len(res.Body)
+	for name, values := range res.Header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return size
+}
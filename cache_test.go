@@ -0,0 +1,137 @@
+package prerendercloud
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_LRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewLRUCache(30, time.Minute)
+
+	cache.Set("a", &CachedResponse{StatusCode: 200, Body: []byte("0123456789")})
+	cache.Set("b", &CachedResponse{StatusCode: 200, Body: []byte("0123456789")})
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("a should be cached")
+	}
+
+	cache.Set("c", &CachedResponse{StatusCode: 200, Body: []byte("0123456789")})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should have been evicted as the least recently used entry")
+	}
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("a should still be cached")
+	}
+
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.Len())
+	}
+}
+
+func Test_LRUCacheSkipsOversizedResponses(t *testing.T) {
+	cache := NewLRUCache(5, time.Minute)
+	cache.Set("a", &CachedResponse{StatusCode: 200, Body: []byte("0123456789")})
+
+	if cache.Len() != 0 {
+		t.Error("responses larger than the cache should not be stored")
+	}
+}
+
+func Test_LRUCacheExpiresEntries(t *testing.T) {
+	now := time.Now()
+	cache := NewLRUCache(1024, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("default-ttl", &CachedResponse{StatusCode: 200})
+	cache.Set("max-age", &CachedResponse{StatusCode: 200, Expires: now.Add(2 * time.Minute)})
+
+	now = now.Add(90 * time.Second)
+
+	if _, ok := cache.Get("default-ttl"); ok {
+		t.Error("entry without Expires should expire after the cache TTL")
+	}
+
+	if _, ok := cache.Get("max-age"); !ok {
+		t.Error("entry should stay fresh until its Expires")
+	}
+}
+
+func Test_cacheExpiry(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		cacheControl string
+		cacheable    bool
+		expires      time.Time
+	}{
+		{"", true, time.Time{}},
+		{"public", true, time.Time{}},
+		{"max-age=60", true, now.Add(time.Minute)},
+		{"public, max-age=60, s-maxage=120", true, now.Add(2 * time.Minute)},
+		{"s-maxage=120, max-age=60", true, now.Add(2 * time.Minute)},
+		{"max-age=0", false, time.Time{}},
+		{"no-store", false, time.Time{}},
+		{"private, max-age=60", false, time.Time{}},
+		{"No-Cache", false, time.Time{}},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("Cache-Control", test.cacheControl)
+
+		expires, cacheable := cacheExpiry(header, now)
+		if cacheable != test.cacheable || !expires.Equal(test.expires) {
+			t.Errorf("%q: expected (%v, %v), got (%v, %v)", test.cacheControl, test.expires, test.cacheable, expires, cacheable)
+		}
+	}
+}
+
+func Test_LRUCacheKeepsStaleEntries(t *testing.T) {
+	now := time.Now()
+	cache := NewLRUCache(1024, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", &CachedResponse{StatusCode: 200, Stale: time.Minute})
+
+	now = now.Add(90 * time.Second)
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("entry should be kept during its stale window")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("entry should be dropped after its stale window")
+	}
+}
+
+func Test_storeOnlyCachesStableStatuses(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		cached     bool
+	}{
+		{200, true},
+		{301, true},
+		{404, true},
+		{410, true},
+		{201, false},
+		{302, false},
+		{401, false},
+		{403, false},
+		{429, false},
+	}
+
+	for _, test := range tests {
+		options := NewOptions()
+		options.Cache = NewLRUCache(1<<20, time.Hour)
+		p := options.NewPrerender()
+
+		p.store("key", &CachedResponse{StatusCode: test.statusCode, Header: http.Header{}}, 0)
+
+		if _, ok := options.Cache.Get("key"); ok != test.cached {
+			t.Errorf("Error, status %d: expected cached to be %v", test.statusCode, test.cached)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package chi plugs a Prerender into a go-chi router, making chi route
+// patterns such as "/users/{id}" available to Route.Pattern.
+package chi
+
+import (
+	"net/http"
+
+	gochi "github.com/go-chi/chi/v5"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+)
+
+// Middleware returns chi middleware for p, for use with Router.Use. Requests
+// that shouldn't be prerendered, and prerenders that fail, are served by the
+// rest of the chain.
+func Middleware(p *prerendercloud.Prerender) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handler := p.Middleware(next)
+
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if pattern := RoutePattern(r); pattern != "" {
+				r = r.WithContext(prerendercloud.WithRoutePattern(r.Context(), pattern))
+			}
+			handler.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// RoutePattern returns the pattern of the chi route r is routed to, such as
+// "/users/{id}", or "" when r isn't being served by a chi router or matches
+// no route. Middleware registered with Router.Use runs before chi has routed
+// the request, so the pattern is looked up ahead of time.
+func RoutePattern(r *http.Request) string {
+	rctx := gochi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+
+	if rctx.Routes == nil {
+		return rctx.RoutePattern()
+	}
+
+	path := rctx.RoutePath
+	if path == "" {
+		path = r.URL.RawPath
+		if path == "" {
+			path = r.URL.Path
+		}
+	}
+
+	// match against a scratch context so chi's own routing isn't disturbed,
+	// keeping the patterns of any routers this one is mounted under
+	tctx := gochi.NewRouteContext()
+	tctx.RoutePatterns = append(tctx.RoutePatterns, rctx.RoutePatterns...)
+	if !rctx.Routes.Match(tctx, r.Method, path) {
+		return ""
+	}
+	return tctx.RoutePattern()
+}
@@ -0,0 +1,246 @@
+package prerendercloud
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through while counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests straight away with a CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen lets a few probe requests through to decide whether to
+	// close the circuit again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// Default CircuitBreaker settings used by NewCircuitBreaker.
+const (
+	DefaultCircuitMinRequests    = 20
+	DefaultCircuitWindow         = time.Minute
+	DefaultCircuitHalfOpenProbes = 3
+)
+
+// CircuitBreaker stops sending requests to the service while it is failing,
+// so requests fall through to the origin right away instead of waiting on a
+// failed upstream call. Network errors, timeouts and 5xx responses count as
+// failures; cancelled requests aren't counted. It is safe for concurrent use
+// but its settings must not be changed once it is in use.
+type CircuitBreaker struct {
+	// FailureThreshold is the fraction of failed requests, from 0 to 1, that
+	// opens the circuit.
+	FailureThreshold float64
+
+	// MinRequests is how many requests a Window needs before the failure
+	// rate is considered.
+	MinRequests int
+
+	// Window is how long failures are counted for before the counts are
+	// reset.
+	Window time.Duration
+
+	// OpenDuration is how long the circuit stays open before probing the
+	// service again.
+	OpenDuration time.Duration
+
+	// HalfOpenProbes is how many requests are let through while half-open.
+	// The circuit closes once that many succeed, and opens again on the
+	// first failure.
+	HalfOpenProbes int
+
+	// OnStateChange, when set, is called after every state change, for
+	// example to record metrics.
+	OnStateChange func(from, to CircuitState)
+
+	now func() time.Time
+
+	mu         sync.Mutex
+	state      CircuitState
+	generation uint64
+	since      time.Time
+	requests   int
+	failures   int
+	probes     int
+	successes  int
+}
+
+// NewCircuitBreaker creates a CircuitBreaker that opens when at least
+// failureThreshold of the requests in a minute fail, and probes the service
+// again after openDuration.
+func NewCircuitBreaker(failureThreshold float64, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		MinRequests:      DefaultCircuitMinRequests,
+		Window:           DefaultCircuitWindow,
+		OpenDuration:     openDuration,
+		HalfOpenProbes:   DefaultCircuitHalfOpenProbes,
+		now:              time.Now,
+	}
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	state, from := cb.current()
+	cb.mu.Unlock()
+
+	cb.notify(from, state)
+	return state
+}
+
+// allow reports whether a request may be sent, and returns the generation to
+// pass to done.
+func (cb *CircuitBreaker) allow() (uint64, bool) {
+	cb.mu.Lock()
+	state, from := cb.current()
+
+	allowed := true
+	switch state {
+	case CircuitOpen:
+		allowed = false
+	case CircuitHalfOpen:
+		probes := cb.HalfOpenProbes
+		if probes <= 0 {
+			probes = 1
+		}
+		if cb.probes >= probes {
+			allowed = false
+		} else {
+			cb.probes++
+		}
+	default:
+		cb.requests++
+	}
+
+	generation := cb.generation
+	cb.mu.Unlock()
+
+	cb.notify(from, state)
+	return generation, allowed
+}
+
+// done records the outcome of a request allowed in generation. A request that
+// was cancelled reports counted false so it only frees its probe slot.
+func (cb *CircuitBreaker) done(generation uint64, failed, counted bool) {
+	cb.mu.Lock()
+	if generation != cb.generation {
+		// the circuit changed state while the request was in flight
+		cb.mu.Unlock()
+		return
+	}
+
+	from, to := cb.state, cb.state
+	switch cb.state {
+	case CircuitHalfOpen:
+		cb.probes--
+		switch {
+		case !counted:
+		case failed:
+			to = cb.setState(CircuitOpen)
+		default:
+			cb.successes++
+			if cb.successes >= cb.HalfOpenProbes {
+				to = cb.setState(CircuitClosed)
+			}
+		}
+	case CircuitClosed:
+		switch {
+		case !counted:
+			cb.requests--
+		case failed:
+			cb.failures++
+			if cb.requests >= cb.MinRequests && float64(cb.failures) >= cb.FailureThreshold*float64(cb.requests) {
+				to = cb.setState(CircuitOpen)
+			}
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
+
+// current applies the transitions due to the passage of time and returns the
+// state along with the state before them. cb.mu must be held.
+func (cb *CircuitBreaker) current() (CircuitState, CircuitState) {
+	from := cb.state
+	now := cb.clock()
+
+	switch cb.state {
+	case CircuitClosed:
+		if cb.since.IsZero() {
+			cb.since = now
+		} else if cb.Window > 0 && !now.Before(cb.since.Add(cb.Window)) {
+			cb.setState(CircuitClosed)
+		}
+	case CircuitOpen:
+		if !now.Before(cb.since.Add(cb.OpenDuration)) {
+			cb.setState(CircuitHalfOpen)
+		}
+	}
+
+	return cb.state, from
+}
+
+// setState moves to state and resets the counts. cb.mu must be held.
+func (cb *CircuitBreaker) setState(state CircuitState) CircuitState {
+	cb.state = state
+	cb.generation++
+	cb.since = cb.clock()
+	cb.requests, cb.failures = 0, 0
+	cb.probes, cb.successes = 0, 0
+	return state
+}
+
+func (cb *CircuitBreaker) clock() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
+
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && cb.OnStateChange != nil {
+		cb.OnStateChange(from, to)
+	}
+}
+
+// admit asks Options.CircuitBreaker whether a request to the service may be
+// sent. It returns a CircuitOpenError when it may not, and otherwise a func
+// recording the response's status code or the error the request failed with.
+func (p *Prerender) admit() (func(status int, err *Error), error) {
+	cb := p.Options.CircuitBreaker
+	if cb == nil {
+		return func(int, *Error) {}, nil
+	}
+
+	generation, ok := cb.allow()
+	if !ok {
+		return nil, &Error{Kind: CircuitOpenError, Err: errors.New("render service is failing")}
+	}
+
+	return func(status int, err *Error) {
+		if err != nil {
+			cb.done(generation, true, err.Kind != CanceledError)
+			return
+		}
+		cb.done(generation, status >= 500, true)
+	}, nil
+}
@@ -0,0 +1,175 @@
+package prerendercloud
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func testBreaker() (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	cb := NewCircuitBreaker(0.5, 10*time.Second)
+	cb.MinRequests = 4
+	cb.HalfOpenProbes = 2
+	cb.now = clock.now
+	return cb, clock
+}
+
+// attempt runs one request through cb and reports whether it was allowed.
+func attempt(cb *CircuitBreaker, failed bool) bool {
+	generation, ok := cb.allow()
+	if ok {
+		cb.done(generation, failed, true)
+	}
+	return ok
+}
+
+func Test_CircuitBreakerOpensOnFailureRate(t *testing.T) {
+	cb, _ := testBreaker()
+
+	attempt(cb, false)
+	attempt(cb, true)
+	attempt(cb, false)
+	if cb.State() != CircuitClosed {
+		t.Errorf("Error, expected closed below MinRequests, got %s", cb.State())
+	}
+
+	attempt(cb, true)
+	if cb.State() != CircuitOpen {
+		t.Errorf("Error, expected open at a 50%% failure rate, got %s", cb.State())
+	}
+
+	if attempt(cb, false) {
+		t.Error("Error, expected requests to be rejected while open")
+	}
+}
+
+func Test_CircuitBreakerStaysClosedBelowThreshold(t *testing.T) {
+	cb, _ := testBreaker()
+
+	for i := 0; i < 10; i++ {
+		attempt(cb, i%3 == 2)
+	}
+
+	if cb.State() != CircuitClosed {
+		t.Errorf("Error, expected closed below the failure threshold, got %s", cb.State())
+	}
+}
+
+func Test_CircuitBreakerWindowResetsCounts(t *testing.T) {
+	cb, clock := testBreaker()
+
+	attempt(cb, true)
+	attempt(cb, true)
+	attempt(cb, true)
+	clock.advance(time.Minute)
+	attempt(cb, true)
+
+	if cb.State() != CircuitClosed {
+		t.Errorf("Error, expected failures from the previous window to be forgotten, got %s", cb.State())
+	}
+}
+
+func Test_CircuitBreakerHalfOpenProbes(t *testing.T) {
+	cb, clock := testBreaker()
+	for i := 0; i < 4; i++ {
+		attempt(cb, true)
+	}
+
+	clock.advance(10 * time.Second)
+	if cb.State() != CircuitHalfOpen {
+		t.Errorf("Error, expected half-open after OpenDuration, got %s", cb.State())
+	}
+
+	first, ok1 := cb.allow()
+	second, ok2 := cb.allow()
+	if _, ok := cb.allow(); !ok1 || !ok2 || ok {
+		t.Errorf("Error, expected exactly 2 probes, got %v %v %v", ok1, ok2, ok)
+	}
+
+	cb.done(first, false, true)
+	if cb.State() != CircuitHalfOpen {
+		t.Errorf("Error, expected half-open until every probe succeeds, got %s", cb.State())
+	}
+
+	cb.done(second, false, true)
+	if cb.State() != CircuitClosed {
+		t.Errorf("Error, expected closed after the probes succeed, got %s", cb.State())
+	}
+}
+
+func Test_CircuitBreakerReopensOnFailedProbe(t *testing.T) {
+	cb, clock := testBreaker()
+	for i := 0; i < 4; i++ {
+		attempt(cb, true)
+	}
+
+	clock.advance(10 * time.Second)
+	attempt(cb, true)
+	if cb.State() != CircuitOpen {
+		t.Errorf("Error, expected open after a failed probe, got %s", cb.State())
+	}
+
+	clock.advance(5 * time.Second)
+	if cb.State() != CircuitOpen {
+		t.Errorf("Error, expected a full OpenDuration after reopening, got %s", cb.State())
+	}
+}
+
+func Test_CircuitBreakerIgnoresCanceledRequests(t *testing.T) {
+	cb, clock := testBreaker()
+	for i := 0; i < 4; i++ {
+		attempt(cb, true)
+	}
+	clock.advance(10 * time.Second)
+
+	generation, _ := cb.allow()
+	cb.done(generation, true, false)
+	if cb.State() != CircuitHalfOpen {
+		t.Errorf("Error, expected a cancelled probe not to count, got %s", cb.State())
+	}
+
+	if !attempt(cb, false) || !attempt(cb, false) {
+		t.Error("Error, expected a cancelled probe to free its slot")
+	}
+	if cb.State() != CircuitClosed {
+		t.Errorf("Error, expected closed after the probes succeed, got %s", cb.State())
+	}
+}
+
+func Test_CircuitBreakerOnStateChange(t *testing.T) {
+	cb, clock := testBreaker()
+
+	var changes []string
+	cb.OnStateChange = func(from, to CircuitState) {
+		changes = append(changes, from.String()+" -> "+to.String())
+	}
+
+	for i := 0; i < 4; i++ {
+		attempt(cb, true)
+	}
+	clock.advance(10 * time.Second)
+	attempt(cb, false)
+	attempt(cb, false)
+
+	expected := []string{"closed -> open", "open -> half-open", "half-open -> closed"}
+	if len(changes) != len(expected) {
+		t.Fatalf("Error, expected %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Error, expected %v, got %v", expected, changes)
+		}
+	}
+}
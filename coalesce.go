@@ -0,0 +1,79 @@
+package prerendercloud
+
+import "context"
+
+// call is an upstream render shared by every request for the same URL that
+// arrives while it is in flight.
+type call struct {
+	done    chan struct{}
+	res     *CachedResponse
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// coalesce runs fn once for concurrent requests with the same upstream URL and
+// hands its result to all of them. fn runs with a context detached from any
+// single caller, so a disconnecting caller (including the first one) doesn't
+// fail the others; it is cancelled only once every caller has gone away. The
+// first caller's deadline is kept, so Options.Timeout and the retry budget
+// still end in time for it.
+func (p *Prerender) coalesce(u upstream, fn func(upstream) (*CachedResponse, error)) (*CachedResponse, error) {
+	key := u.key()
+	ctx := u.context()
+
+	p.callsMu.Lock()
+	if p.calls == nil {
+		p.calls = make(map[string]*call)
+	}
+
+	c, ok := p.calls[key]
+	if !ok {
+		detached, cancel := detach(ctx)
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		p.calls[key] = c
+
+		shared := u.detach(detached, true)
+		go func() {
+			defer shared.release()
+			c.res, c.err = fn(shared)
+
+			p.callsMu.Lock()
+			if p.calls[key] == c {
+				delete(p.calls, key)
+			}
+			p.callsMu.Unlock()
+
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	p.callsMu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.res, c.err
+	case <-ctx.Done():
+		p.callsMu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if p.calls[key] == c {
+				delete(p.calls, key)
+			}
+		}
+		p.callsMu.Unlock()
+
+		return nil, transportError(ctx.Err(), NetworkError)
+	}
+}
+
+// detach returns a context carrying ctx's values and deadline but not its
+// cancellation.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	}
+	return context.WithCancel(context.WithoutCancel(ctx))
+}
@@ -0,0 +1,283 @@
+package prerendercloud
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// blockingPrerender returns a Prerender whose upstream blocks until release is
+// closed, counting upstream calls.
+func blockingPrerender(calls *int32, release chan struct{}) *Prerender {
+	options := NewOptions()
+	options.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(calls, 1)
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("prerendered response")),
+		}, nil
+	})}
+	return options.NewPrerender()
+}
+
+func waitForWaiters(p *Prerender, key string, n int) {
+	for {
+		p.callsMu.Lock()
+		c := p.calls[key]
+		ready := c != nil && c.waiters == n
+		p.callsMu.Unlock()
+		if ready {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_renderCoalescesConcurrentRequests(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	p := blockingPrerender(&calls, release)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+			res, err := p.render(&httpUpstream{p: p, req: req}, 0)
+			if err != nil || string(res.Body) != "prerendered response" {
+				t.Errorf("unexpected result %v %v", res, err)
+			}
+		}()
+	}
+
+	waitForWaiters(p, "https://service.headless-render-api.com/http://example.org/", 10)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls)
+	}
+}
+
+func Test_renderSurvivesLeaderCancellation(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	p := blockingPrerender(&calls, release)
+	key := "https://service.headless-render-api.com/http://example.org/leader"
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		req, _ := http.NewRequestWithContext(leaderCtx, "GET", key, nil)
+		_, err := p.render(&httpUpstream{p: p, req: req}, 0)
+		leaderErr <- err
+	}()
+	waitForWaiters(p, key, 1)
+
+	followerRes := make(chan *CachedResponse)
+	go func() {
+		req, _ := http.NewRequest("GET", key, nil)
+		res, _ := p.render(&httpUpstream{p: p, req: req}, 0)
+		followerRes <- res
+	}()
+	waitForWaiters(p, key, 2)
+
+	cancelLeader()
+	if err := <-leaderErr; !IsErrorKind(err, CanceledError) {
+		t.Errorf("expected the leader to be cancelled, got %v", err)
+	}
+
+	close(release)
+	if res := <-followerRes; res == nil || string(res.Body) != "prerendered response" {
+		t.Error("follower should still receive the render after the leader is cancelled")
+	}
+}
+
+func Test_renderCancelsUpstreamWhenAllWaitersLeave(t *testing.T) {
+	var calls int32
+	p := blockingPrerender(&calls, make(chan struct{}))
+	key := "https://service.headless-render-api.com/http://example.org/abandoned"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, "GET", key, nil)
+		p.render(&httpUpstream{p: p, req: req}, 0)
+		close(done)
+	}()
+	waitForWaiters(p, key, 1)
+	cancel()
+	<-done
+
+	// a new request must start a new upstream call rather than join the
+	// abandoned one
+	p.callsMu.Lock()
+	_, inFlight := p.calls[key]
+	p.callsMu.Unlock()
+	if inFlight {
+		t.Error("abandoned render should no longer be shared")
+	}
+}
+
+func Test_renderKeepsWhitelistedHeadersApart(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	options := NewOptions()
+	options.Cache = NewLRUCache(1<<20, time.Minute)
+	options.Render = &RenderOptions{OriginHeaderWhitelist: []string{"Authorization"}}
+	options.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Cache-Control": {"max-age=60"}},
+			Body:       ioutil.NopCloser(strings.NewReader("rendered for " + req.Header.Get("Authorization"))),
+		}, nil
+	})}
+	p := options.NewPrerender()
+
+	serve := func(user string) string {
+		req := httptest.NewRequest("GET", "http://example.org/account", nil)
+		req.Header.Set("User-Agent", "twitterbot")
+		req.Header.Set("Authorization", user)
+		rec := httptest.NewRecorder()
+		if err := p.PreRender(rec, req); err != nil {
+			t.Errorf("Error, %s: %v", user, err)
+		}
+		return rec.Body.String()
+	}
+
+	results := make(map[string]chan string)
+	for _, user := range []string{"alice", "bob"} {
+		result := make(chan string, 1)
+		results[user] = result
+		go func(user string) { result <- serve(user) }(user)
+	}
+
+	// both renders must be in flight at once rather than shared
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) < 2 {
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatal("Error, renders for different users were shared")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for _, user := range []string{"alice", "bob"} {
+		if body := <-results[user]; body != "rendered for "+user {
+			t.Errorf("Error, %s got %q", user, body)
+		}
+	}
+
+	if body := serve("bob"); body != "rendered for bob" {
+		t.Errorf("Error, bob got %q from the cache", body)
+	}
+	if calls != 2 {
+		t.Errorf("Error, expected the cached renders to be reused, got %d upstream calls", calls)
+	}
+}
+
+// boundClientFactory returns a ClientFactory whose clients only work while
+// their request is being served, like App Engine's urlfetch clients.
+func boundClientFactory(calls *int32) func(*http.Request) *http.Client {
+	return func(or *http.Request) *http.Client {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(calls, 1)
+			<-or.Context().Done()
+			return nil, or.Context().Err()
+		})}
+	}
+}
+
+func Test_renderOutlivesClientFactoryRequest(t *testing.T) {
+	var factoryCalls int32
+	options := NewOptions()
+	options.ClientFactory = boundClientFactory(&factoryCalls)
+	options.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("prerendered response"))}, nil
+	})}
+	p := options.NewPrerender()
+	key := "https://service.headless-render-api.com/http://example.org/"
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("GET", "http://example.org/", nil).WithContext(leaderCtx)
+		req.Header.Set("User-Agent", "twitterbot")
+		p.PreRender(httptest.NewRecorder(), req)
+		close(leaderDone)
+	}()
+	waitForWaiters(p, key, 1)
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan error)
+	go func() {
+		req := httptest.NewRequest("GET", "http://example.org/", nil)
+		req.Header.Set("User-Agent", "twitterbot")
+		followerDone <- p.PreRender(follower, req)
+	}()
+	waitForWaiters(p, key, 2)
+
+	cancelLeader()
+	<-leaderDone
+	if err := <-followerDone; err != nil || follower.Body.String() != "prerendered response" {
+		t.Errorf("Error, the follower should get the render through HTTPClient once the leader is gone, got %q %v", follower.Body.String(), err)
+	}
+	if factoryCalls != 1 {
+		t.Errorf("Error, expected the ClientFactory client to be used only while the leader was served, got %d calls", factoryCalls)
+	}
+}
+
+func Test_refreshDoesNotUseClientFactory(t *testing.T) {
+	var factoryCalls int32
+	refreshed := make(chan struct{})
+	options := NewOptions()
+	options.Cache = NewLRUCache(1<<20, time.Hour)
+	options.StaleWhileRevalidate = time.Minute
+	options.ClientFactory = boundClientFactory(&factoryCalls)
+	options.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		defer close(refreshed)
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("fresh response"))}, nil
+	})}
+	p := options.NewPrerender()
+	options.Cache.Set("https://service.headless-render-api.com/http://example.org/", &CachedResponse{
+		StatusCode: 200, Header: http.Header{}, Body: []byte("stale response"), Expires: time.Now().Add(-time.Second), Stale: time.Minute,
+	})
+
+	req := httptest.NewRequest("GET", "http://example.org/", nil)
+	req.Header.Set("User-Agent", "twitterbot")
+	res := httptest.NewRecorder()
+	if err := p.PreRender(res, req); err != nil || res.Body.String() != "stale response" {
+		t.Errorf("Error, expected the stale response, got %q %v", res.Body.String(), err)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("Error, the background refresh should use HTTPClient")
+	}
+	if factoryCalls != 0 {
+		t.Errorf("Error, the background refresh should not use the ClientFactory client, got %d calls", factoryCalls)
+	}
+}
@@ -0,0 +1,34 @@
+// Package echo plugs a Prerender into an Echo server, making Echo route paths
+// such as "/users/:id" available to Route.Pattern.
+package echo
+
+import (
+	"net/http"
+
+	labecho "github.com/labstack/echo/v4"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+)
+
+// Middleware returns Echo middleware for p, for use with Echo.Use. Requests
+// that shouldn't be prerendered, and prerenders that fail, are served by next.
+func Middleware(p *prerendercloud.Prerender) labecho.MiddlewareFunc {
+	return func(next labecho.HandlerFunc) labecho.HandlerFunc {
+		return func(c labecho.Context) error {
+			r := c.Request()
+			if pattern := c.Path(); pattern != "" {
+				r = r.WithContext(prerendercloud.WithRoutePattern(r.Context(), pattern))
+				c.SetRequest(r)
+			}
+
+			if !p.ShouldPrerender(r) {
+				return next(c)
+			}
+
+			var err error
+			p.PreRenderHandler(c.Response(), r, func(http.ResponseWriter, *http.Request) {
+				err = next(c)
+			})
+			return err
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package prerendercloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies why a request to the Prerender.cloud service failed.
+type ErrorKind int
+
+const (
+	// RequestError means the upstream request could not be built, usually
+	// because the incoming request had a malformed host or path.
+	RequestError ErrorKind = iota + 1
+	// NetworkError means the service could not be reached (DNS failure,
+	// connection refused, connection reset).
+	NetworkError
+	// TimeoutError means the service did not respond in time.
+	TimeoutError
+	// UpstreamError means the service responded with a 5xx status code.
+	UpstreamError
+	// DecodeError means the response body could not be read or decompressed.
+	DecodeError
+	// CanceledError means the incoming request's context was cancelled, for
+	// example because the client disconnected or the server is shutting down.
+	CanceledError
+	// APIError means the service rejected an API request, such as a
+	// screenshot, with a 4xx status code. Err holds the service's message.
+	APIError
+	// CircuitOpenError means the request wasn't sent because
+	// Options.CircuitBreaker is open after too many failures.
+	CircuitOpenError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case RequestError:
+		return "request error"
+	case NetworkError:
+		return "network error"
+	case TimeoutError:
+		return "timeout"
+	case UpstreamError:
+		return "upstream error"
+	case DecodeError:
+		return "decode error"
+	case CanceledError:
+		return "canceled"
+	case APIError:
+		return "api error"
+	case CircuitOpenError:
+		return "circuit open"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// Error is returned when a request to the Prerender.cloud service fails.
+// Nothing has been written to the downstream response when an Error is
+// returned, so the caller can still serve the request itself.
+type Error struct {
+	Kind ErrorKind
+
+	// StatusCode is the upstream status code for an UpstreamError or an
+	// APIError.
+	StatusCode int
+
+	// Err is the underlying error, nil for an UpstreamError from the
+	// prerender handlers.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("prerendercloud: %s (status %d)", e.Kind, e.StatusCode)
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("prerendercloud: %s (status %d): %v", e.Kind, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("prerendercloud: %s: %v", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsErrorKind reports whether err is an *Error of the given kind.
+func IsErrorKind(err error, kind ErrorKind) bool {
+	var perr *Error
+	return errors.As(err, &perr) && perr.Kind == kind
+}
+
+// transportError classifies an error returned by http.Client.Do,
+// fasthttp.Client.Do or by reading a response body.
+func transportError(err error, fallback ErrorKind) *Error {
+	// net.Error timeouts, and fasthttp's ErrTimeout, which only has Timeout
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.Canceled) {
+		return &Error{Kind: CanceledError, Err: err}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()) {
+		return &Error{Kind: TimeoutError, Err: err}
+	}
+	return &Error{Kind: fallback, Err: err}
+}
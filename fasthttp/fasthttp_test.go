@@ -2,22 +2,50 @@ package fasthttp_test
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
+	"sync"
 	"testing"
 
-	"gopkg.in/jarcoal/httpmock.v1"
-
 	"github.com/sanfrancesco/prerendercloud-golang"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 var listener *fasthttputil.InmemoryListener
+var middlewareListener *fasthttputil.InmemoryListener
+var prerenderCloud *prerendercloud.Prerender
+
+// upstream stands in for the service: it answers with the responders
+// registered for the paths it is asked to render, and 404 otherwise.
+var upstream = fasthttputil.NewInmemoryListener()
+var upstreamResponders sync.Map
+
+// registerResponder makes the service answer renders of page with
+// statusCode and body.
+func registerResponder(page string, statusCode int, body string) {
+	upstreamResponders.Store("/"+page, fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(statusCode)
+		ctx.SetBodyString(body)
+	}))
+}
+
+func newUpstreamClient() *fasthttp.Client {
+	client := prerendercloud.NewFastHTTPClient()
+	client.Dial = func(addr string) (net.Conn, error) { return upstream.Dial() }
+	return client
+}
 
 func makeRequest(url string, alreadyPrerendered bool, userAgent string) ([]byte, int, error) {
+	return makeRequestTo(listener, url, alreadyPrerendered, userAgent)
+}
+
+func makeRequestTo(listener *fasthttputil.InmemoryListener, url string, alreadyPrerendered bool, userAgent string) ([]byte, int, error) {
 	req, _ := http.NewRequest("GET", url, nil)
 
 	req.Header.Set("User-Agent", userAgent)
@@ -60,11 +88,20 @@ func makeRequest(url string, alreadyPrerendered bool, userAgent string) ([]byte,
 }
 
 func TestMain(m *testing.M) {
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
+	go (&fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if responder, ok := upstreamResponders.Load(string(ctx.RequestURI())); ok {
+				responder.(fasthttp.RequestHandler)(ctx)
+				return
+			}
+			ctx.NotFound()
+		},
+	}).Serve(upstream)
 
 	prerenderCloudOptions := prerendercloud.NewOptions()
-	prerenderCloud := prerenderCloudOptions.NewPrerender()
+	prerenderCloudOptions.PrerenderURL, _ = url.Parse("http://service.test/")
+	prerenderCloudOptions.FastHTTPClient = newUpstreamClient()
+	prerenderCloud = prerenderCloudOptions.NewPrerender()
 
 	server := &fasthttp.Server{
 		Handler: func(ctx *fasthttp.RequestCtx) {
@@ -90,6 +127,20 @@ func TestMain(m *testing.M) {
 
 	}()
 
+	middlewareServer := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set("X-Before", "set before the middleware")
+			prerenderCloud.FastHTTPMiddleware(func(ctx *fasthttp.RequestCtx) {
+				// the origin reports what the failed prerender left behind
+				fmt.Fprintf(ctx, "origin %d %q %q", ctx.Response.StatusCode(), ctx.Response.Body(), ctx.Response.Header.Peek("X-Before"))
+			})(ctx)
+		},
+		Name: "middleware test server",
+	}
+
+	middlewareListener = fasthttputil.NewInmemoryListener()
+	go middlewareServer.Serve(middlewareListener)
+
 	os.Exit(m.Run())
 }
 
@@ -106,7 +157,7 @@ func Test_NoUserAgentRequest(t *testing.T) {
 }
 
 func Test_WithUserAgentRequest(t *testing.T) {
-	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/", httpmock.NewStringResponder(201, `prerendered response`))
+	registerResponder("http://www.example.com/", 201, `prerendered response`)
 
 	body, statusCode, err := makeRequest("http://www.example.com/", false, "example-user-agent")
 	if err != nil {
@@ -136,7 +187,7 @@ func Test_WithPrerendercloudUserAgentRequest(t *testing.T) {
 }
 
 func Test_withHtmlExtension(t *testing.T) {
-	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/deep/path.html", httpmock.NewStringResponder(200, `prerendered response`))
+	registerResponder("http://www.example.com/deep/path.html", 200, `prerendered response`)
 
 	body, _, err := makeRequest("http://www.example.com/deep/path.html", false, "example-user-agent")
 	if err != nil {
@@ -149,7 +200,7 @@ func Test_withHtmlExtension(t *testing.T) {
 }
 
 func withNoExtension(t *testing.T) {
-	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/deep/path", httpmock.NewStringResponder(200, `prerendered response`))
+	registerResponder("http://www.example.com/deep/path", 200, `prerendered response`)
 
 	body, _, err := makeRequest("http://www.example.com/deep/path", false, "example-user-agent")
 	if err != nil {
@@ -186,7 +237,7 @@ func Test_WithUserAgentAndAlreadyPrerenderedRequest(t *testing.T) {
 }
 
 func Test_WithServerErrorAndNextMiddleware(t *testing.T) {
-	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/", httpmock.NewStringResponder(500, `server error`))
+	registerResponder("http://www.example.com/", 500, `server error`)
 
 	body, statusCode, err := makeRequest("http://www.example.com/", false, "example-user-agent")
 	if err != nil {
@@ -203,3 +254,93 @@ func Test_WithServerErrorAndNextMiddleware(t *testing.T) {
 		t.Error("Error, middleware should return response from next middleware when server returns 500")
 	}
 }
+
+// unreachable makes the service unreachable until t ends.
+func unreachable(t *testing.T) {
+	client := prerenderCloud.Options.FastHTTPClient
+	prerenderCloud.Options.FastHTTPClient = prerendercloud.NewFastHTTPClient()
+	prerenderCloud.Options.FastHTTPClient.Dial = func(addr string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	t.Cleanup(func() { prerenderCloud.Options.FastHTTPClient = client })
+}
+
+func Test_WithNetworkErrorAndNextMiddleware(t *testing.T) {
+	unreachable(t)
+
+	body, statusCode, err := makeRequest("http://www.example.com/unreachable", false, "example-user-agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if statusCode != 200 || string(body) != "origin" {
+		fmt.Printf("actual response %#v %#v\n", statusCode, string(body))
+		t.Error("Error, middleware should return origin response when prerender.cloud is unreachable")
+	}
+}
+
+func Test_WithExcludedPath(t *testing.T) {
+	prerenderCloud.Options.ExcludePaths = []prerendercloud.PathPattern{prerendercloud.Glob("/admin/**")}
+	defer func() { prerenderCloud.Options.ExcludePaths = nil }()
+
+	body, _, err := makeRequest("http://www.example.com/admin/users", false, "example-user-agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if string(body) != "origin" {
+		t.Error("expected origin response for an excluded path")
+	}
+}
+
+func Test_FastHTTPMiddlewareWithUserAgentRequest(t *testing.T) {
+	registerResponder("http://www.example.com/middleware", 201, `prerendered response`)
+
+	body, statusCode, err := makeRequestTo(middlewareListener, "http://www.example.com/middleware", false, "example-user-agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if statusCode != 201 || string(body) != "prerendered response" {
+		fmt.Printf("actual response %#v %#v\n", statusCode, string(body))
+		t.Error("expected prerendered response")
+	}
+}
+
+func Test_FastHTTPMiddlewareNoUserAgentRequest(t *testing.T) {
+	body, _, err := makeRequestTo(middlewareListener, "http://www.example.com/middleware", false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if string(body) != `origin 200 "" "set before the middleware"` {
+		fmt.Printf("actual response %#v\n", string(body))
+		t.Error("expected origin response")
+	}
+}
+
+func Test_FastHTTPMiddlewareWithServerError(t *testing.T) {
+	registerResponder("http://www.example.com/middleware/500", 500, `server error`)
+
+	body, statusCode, err := makeRequestTo(middlewareListener, "http://www.example.com/middleware/500", false, "example-user-agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if statusCode != 200 || string(body) != `origin 200 "" "set before the middleware"` {
+		fmt.Printf("actual response %#v %#v\n", statusCode, string(body))
+		t.Error("Error, middleware should hand next an untouched ctx when server returns 500")
+	}
+}
+
+func Test_FastHTTPMiddlewareWithNetworkError(t *testing.T) {
+	unreachable(t)
+
+	body, statusCode, err := makeRequestTo(middlewareListener, "http://www.example.com/middleware/unreachable", false, "example-user-agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if statusCode != 200 || string(body) != `origin 200 "" "set before the middleware"` {
+		fmt.Printf("actual response %#v %#v\n", statusCode, string(body))
+		t.Error("Error, middleware should hand next an untouched ctx when prerender.cloud is unreachable")
+	}
+}
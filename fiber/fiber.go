@@ -0,0 +1,30 @@
+// Package fiber plugs a Prerender into a Fiber app through the fasthttp
+// handler.
+package fiber
+
+import (
+	gofiber "github.com/gofiber/fiber/v2"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+)
+
+// Middleware returns Fiber middleware for p, for use with App.Use. Requests
+// that shouldn't be prerendered, and prerenders that fail, continue with
+// c.Next. Fiber runs middleware before it has matched a route, so
+// Route.Pattern doesn't apply; use Route.Path instead.
+func Middleware(p *prerendercloud.Prerender) gofiber.Handler {
+	return func(c *gofiber.Ctx) error {
+		ctx := c.Context()
+		if !p.ShouldPrerenderFastHttp(ctx) {
+			return c.Next()
+		}
+
+		err := p.PreRenderHandlerFastHttp(ctx)
+		if prerendercloud.IsErrorKind(err, prerendercloud.CanceledError) {
+			return nil
+		}
+		if err != nil {
+			return c.Next()
+		}
+		return nil
+	}
+}
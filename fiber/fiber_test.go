@@ -0,0 +1,93 @@
+package fiber
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	gofiber "github.com/gofiber/fiber/v2"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+	"github.com/sanfrancesco/prerendercloud-golang/internal/adaptertest"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestMain(m *testing.M) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	os.Exit(m.Run())
+}
+
+func newApp(options *prerendercloud.Options) *gofiber.App {
+	next := func(c *gofiber.Ctx) error {
+		return c.SendString("next handler")
+	}
+
+	app := gofiber.New()
+	app.Use(Middleware(options.NewPrerender()))
+	app.Get("/", next)
+	app.Get("/style.woff", next)
+	app.Get("/users/:id", next)
+	app.Get("/api/users", next)
+	return app
+}
+
+func serve(t *testing.T, app *gofiber.App, url, userAgent string) (*http.Response, string) {
+	req := httptest.NewRequest("GET", url, nil)
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func Test_Middleware(t *testing.T) {
+	adaptertest.Run(t, func(t *testing.T, options *prerendercloud.Options, url, userAgent string) (int, string) {
+		adaptertest.FastHTTPStandIn(t, options)
+		res, body := serve(t, newApp(options), url, userAgent)
+		return res.StatusCode, body
+	})
+}
+
+func Test_WithExcludedPath(t *testing.T) {
+	options := prerendercloud.NewOptions()
+	options.ExcludePaths = []prerendercloud.PathPattern{prerendercloud.Glob("/api/**")}
+
+	_, body := serve(t, newApp(options), "http://www.example.com/api/users", "example-user-agent")
+
+	if body != "next handler" {
+		fmt.Printf("actual response %#v\n", body)
+		t.Error("Error, prerender.cloud should not have been called for an excluded path")
+	}
+}
+
+func Test_WithRoutePath(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/users/7", func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Prerender-Wait-Extra-Long") != "true" {
+			return httpmock.NewStringResponse(200, "without route options"), nil
+		}
+		return httpmock.NewStringResponse(200, "with route options"), nil
+	})
+
+	options := prerendercloud.NewOptions()
+	options.Routes = []prerendercloud.Route{
+		{Path: prerendercloud.Glob("/users/*"), Render: &prerendercloud.RenderOptions{WaitExtraLong: true}},
+	}
+	adaptertest.FastHTTPStandIn(t, options)
+
+	_, body := serve(t, newApp(options), "http://www.example.com/users/7", "example-user-agent")
+
+	if body != "with route options" {
+		fmt.Printf("actual response %#v\n", body)
+		t.Error("Error, the render options of the matching route should be sent")
+	}
+}
@@ -0,0 +1,144 @@
+package prerendercloud
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// origin returns the scheme and host the client used to reach the site. The
+// Forwarded, X-Forwarded-Proto, X-Forwarded-Host and CF-Visitor headers are
+// honored, in that order, only when the request comes from one of
+// Options.TrustedProxies. Proxies append to these headers, so values are read
+// from the right: the leftmost ones may have been sent by the client itself.
+func (p *Prerender) origin(v requestView) (scheme, host string) {
+	scheme, host = v.scheme(), v.host()
+	if v.isTLS() {
+		scheme = "https"
+	}
+
+	if !p.trustsPeer(v.remoteAddr()) {
+		return scheme, host
+	}
+
+	forwardedProto, forwardedHost := p.parseForwarded(v.header("Forwarded"))
+
+	if proto := firstNonEmpty(
+		forwardedProto,
+		validScheme(lastValue(v.header("X-Forwarded-Proto"))),
+		cfVisitorScheme(v.header("CF-Visitor")),
+	); proto != "" {
+		scheme = proto
+	}
+
+	if h := firstNonEmpty(forwardedHost, validHost(lastValue(v.header("X-Forwarded-Host")))); h != "" {
+		host = h
+	}
+
+	return scheme, host
+}
+
+func (p *Prerender) trustsPeer(remoteAddr string) bool {
+	if len(p.Options.TrustedProxies) == 0 {
+		return false
+	}
+
+	addr, err := netip.ParseAddrPort(remoteAddr)
+	ip := addr.Addr()
+	if err != nil {
+		if ip, err = netip.ParseAddr(remoteAddr); err != nil {
+			return false
+		}
+	}
+	ip = ip.Unmap()
+
+	for _, prefix := range p.Options.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded returns the proto and host parameters of the RFC 7239
+// Forwarded element describing the client-facing hop. Each proxy appends an
+// element whose for= is its peer, so starting from the element added by the
+// nearest proxy, elements are skipped while for= is one of
+// Options.TrustedProxies; the element a trusted proxy added for an untrusted
+// peer is the one used.
+func (p *Prerender) parseForwarded(header string) (proto, host string) {
+	if header == "" {
+		return "", ""
+	}
+
+	elements := strings.Split(header, ",")
+	for i := len(elements) - 1; i >= 0; i-- {
+		var forwardedFor string
+		proto, host = "", ""
+
+		for _, pair := range strings.Split(elements[i], ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+
+			switch strings.ToLower(name) {
+			case "for":
+				forwardedFor = value
+				if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+					forwardedFor = value[1 : len(value)-1]
+				}
+			case "proto":
+				proto = validScheme(value)
+			case "host":
+				host = validHost(value)
+			}
+		}
+
+		if !p.trustsPeer(forwardedFor) {
+			break
+		}
+	}
+
+	return proto, host
+}
+
+func cfVisitorScheme(header string) string {
+	if match := cfSchemeRegex.FindStringSubmatch(header); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// lastValue returns the value the nearest proxy added to a comma separated
+// X-Forwarded-* header.
+func lastValue(header string) string {
+	if i := strings.LastIndex(header, ","); i >= 0 {
+		header = header[i+1:]
+	}
+	return strings.TrimSpace(header)
+}
+
+func validScheme(scheme string) string {
+	scheme = strings.ToLower(scheme)
+	if scheme == "http" || scheme == "https" {
+		return scheme
+	}
+	return ""
+}
+
+func validHost(host string) string {
+	if host == "" || strings.ContainsAny(host, "/\\@?# \t") {
+		return ""
+	}
+	return host
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
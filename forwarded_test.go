@@ -0,0 +1,64 @@
+package prerendercloud
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func Test_origin(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		headers    map[string]string
+		scheme     string
+		host       string
+	}{
+		{"plain", "10.0.0.1:1234", false, nil, "", "www.example.com"},
+		{"tls", "10.0.0.1:1234", true, nil, "https", "www.example.com"},
+		{"x-forwarded", "10.0.0.1:1234", false, map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.org"}, "https", "example.org"},
+		{"x-forwarded list", "10.0.0.1:1234", false, map[string]string{"X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "attacker.example, example.org"}, "https", "example.org"},
+		{"x-forwarded invalid", "10.0.0.1:1234", false, map[string]string{"X-Forwarded-Proto": "javascript", "X-Forwarded-Host": "evil.example/path"}, "", "www.example.com"},
+		{"forwarded", "10.0.0.1:1234", false, map[string]string{"Forwarded": `for=192.0.2.60;proto=https;host="example.org:8443", for=10.0.0.2;proto=http`}, "https", "example.org:8443"},
+		{"forwarded from client", "10.0.0.1:1234", false, map[string]string{"Forwarded": `proto=http;host=attacker.example, for=203.0.113.9;proto=https;host=example.org`}, "https", "example.org"},
+		{"forwarded through trusted proxies", "10.0.0.1:1234", false, map[string]string{"Forwarded": `host=attacker.example, for="[2001:db8::1]:4711";proto=https;host=example.org, for="[fd00::2]";proto=http;host=internal`}, "https", "example.org"},
+		{"forwarded wins", "10.0.0.1:1234", false, map[string]string{"Forwarded": "proto=http", "X-Forwarded-Proto": "https"}, "http", "www.example.com"},
+		{"cf-visitor", "10.0.0.1:1234", false, map[string]string{"CF-Visitor": `{"scheme":"https"}`}, "https", "www.example.com"},
+		{"ipv6 proxy", "[fd00::1]:1234", false, map[string]string{"X-Forwarded-Proto": "https"}, "https", "www.example.com"},
+		{"untrusted peer", "203.0.113.9:1234", false, map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.org", "CF-Visitor": `{"scheme":"https"}`}, "", "www.example.com"},
+	}
+
+	options := NewOptions()
+	options.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	p := options.NewPrerender()
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Host = "www.example.com"
+		req.RemoteAddr = test.remoteAddr
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+
+		scheme, host := p.origin(httpRequest{req})
+		if scheme != test.scheme || host != test.host {
+			t.Errorf("%s: expected (%q, %q), got (%q, %q)", test.name, test.scheme, test.host, scheme, host)
+		}
+	}
+}
+
+func Test_originIgnoresHeadersWithoutTrustedProxies(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Host = "www.example.com"
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	if scheme, _ := NewOptions().NewPrerender().origin(httpRequest{req}); scheme != "" {
+		t.Error("forwarded headers should be ignored unless TrustedProxies is set")
+	}
+}
@@ -0,0 +1,36 @@
+// Package gin plugs a Prerender into a Gin engine, making Gin route paths
+// such as "/users/:id" available to Route.Pattern.
+package gin
+
+import (
+	"net/http"
+
+	gingonic "github.com/gin-gonic/gin"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+)
+
+// Middleware returns Gin middleware for p, for use with Engine.Use. Requests
+// that shouldn't be prerendered, and prerenders that fail, continue down the
+// handler chain; prerendered requests abort it.
+func Middleware(p *prerendercloud.Prerender) gingonic.HandlerFunc {
+	return func(c *gingonic.Context) {
+		if pattern := c.FullPath(); pattern != "" {
+			c.Request = c.Request.WithContext(prerendercloud.WithRoutePattern(c.Request.Context(), pattern))
+		}
+
+		if !p.ShouldPrerender(c.Request) {
+			c.Next()
+			return
+		}
+
+		fellThrough := false
+		p.PreRenderHandler(c.Writer, c.Request, func(http.ResponseWriter, *http.Request) {
+			fellThrough = true
+			c.Next()
+		})
+
+		if !fellThrough {
+			c.Abort()
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package gin
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	gingonic "github.com/gin-gonic/gin"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+	"github.com/sanfrancesco/prerendercloud-golang/internal/adaptertest"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestMain(m *testing.M) {
+	gingonic.SetMode(gingonic.TestMode)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	os.Exit(m.Run())
+}
+
+func newRouter(options *prerendercloud.Options) *gingonic.Engine {
+	next := func(c *gingonic.Context) {
+		c.String(http.StatusOK, "next handler")
+	}
+
+	r := gingonic.New()
+	r.Use(Middleware(options.NewPrerender()))
+	r.GET("/", next)
+	r.GET("/style.woff", next)
+	r.GET("/users/:id", next)
+	blog := r.Group("/blog")
+	blog.GET("/:slug", next)
+	return r
+}
+
+func Test_Middleware(t *testing.T) {
+	adaptertest.Run(t, adaptertest.HTTPServer(func(options *prerendercloud.Options) http.Handler {
+		return newRouter(options)
+	}))
+}
+
+func Test_RoutePattern(t *testing.T) {
+	options := prerendercloud.NewOptions()
+	botsOnly := true
+	options.Routes = []prerendercloud.Route{
+		{Pattern: "/users/:id", BotsOnly: &botsOnly},
+		{Pattern: "/blog/:slug", BotsOnly: &botsOnly},
+	}
+	r := newRouter(options)
+
+	for _, url := range []string{"http://www.example.com/users/42", "http://www.example.com/blog/hello"} {
+		httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/"+url, httpmock.NewStringResponder(200, `prerendered response`))
+
+		res := adaptertest.Serve(r, url, "example-user-agent")
+		if string(res.Body.Bytes()) != "next handler" {
+			fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+			t.Errorf("Error, the route pattern's BotsOnly should skip prerendering %s for a non-bot", url)
+		}
+
+		res = adaptertest.Serve(r, url, "Twitterbot/1.0")
+		if string(res.Body.Bytes()) != "prerendered response" {
+			fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+			t.Errorf("Error, the route pattern's BotsOnly should prerender %s for a bot", url)
+		}
+	}
+}
+
+func Test_RoutePatternWithRenderOptions(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/users/7", func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Prerender-Wait-Extra-Long") != "true" {
+			return httpmock.NewStringResponse(200, "without route options"), nil
+		}
+		return httpmock.NewStringResponse(200, "with route options"), nil
+	})
+
+	options := prerendercloud.NewOptions()
+	options.Routes = []prerendercloud.Route{
+		{Pattern: "/users/:id", Render: &prerendercloud.RenderOptions{WaitExtraLong: true}},
+	}
+
+	res := adaptertest.Serve(newRouter(options), "http://www.example.com/users/7", "example-user-agent")
+
+	if string(res.Body.Bytes()) != "with route options" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, the render options of the matching route pattern should be sent")
+	}
+}
+
+func Test_PrerenderedRequestAbortsChain(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/users/abort", httpmock.NewStringResponder(200, `prerendered response`))
+
+	ranAfter := false
+	r := gingonic.New()
+	r.Use(Middleware(prerendercloud.NewOptions().NewPrerender()))
+	r.Use(func(c *gingonic.Context) {
+		ranAfter = true
+	})
+	r.GET("/users/:id", func(c *gingonic.Context) {
+		c.String(http.StatusOK, "next handler")
+	})
+
+	res := adaptertest.Serve(r, "http://www.example.com/users/abort", "example-user-agent")
+
+	if string(res.Body.Bytes()) != "prerendered response" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, prerender.cloud should have been called when the request had a user-agent present")
+	}
+
+	if ranAfter {
+		t.Error("Error, handlers after the middleware should not run for prerendered requests")
+	}
+}
@@ -0,0 +1,42 @@
+package prerendercloud
+
+import (
+	"net"
+	"strings"
+)
+
+// HostAllowed reports whether host may be prerendered according to
+// AllowedHosts. Entries are exact host names, optionally with a port, or
+// wildcards like "*.example.com" matching any subdomain of example.com. Ports
+// are ignored unless the entry has one. Every host is allowed when
+// AllowedHosts is empty.
+func (o *Options) HostAllowed(host string) bool {
+	if len(o.AllowedHosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, allowed := range o.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+
+		candidate := hostname
+		if _, _, err := net.SplitHostPort(allowed); err == nil {
+			candidate = host
+		}
+
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(candidate, allowed[1:]) && len(candidate) > len(allowed)-1 {
+				return true
+			}
+		} else if candidate == allowed {
+			return true
+		}
+	}
+
+	return false
+}
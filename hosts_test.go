@@ -0,0 +1,90 @@
+package prerendercloud
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func Test_HostAllowed(t *testing.T) {
+	options := &Options{AllowedHosts: []string{"example.com", "*.example.com", "localhost:8080"}}
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"example.com:443", true},
+		{"example.com.", true},
+		{"www.example.com", true},
+		{"a.b.example.com", true},
+		{"localhost:8080", true},
+		{"localhost:9090", false},
+		{"localhost", false},
+		{"evil.example", false},
+		{"example.com.evil.example", false},
+		{"evilexample.com", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if options.HostAllowed(test.host) != test.allowed {
+			t.Errorf("HostAllowed(%q) should be %v", test.host, test.allowed)
+		}
+	}
+
+	if !(&Options{}).HostAllowed("anything.example") {
+		t.Error("every host should be allowed when AllowedHosts is empty")
+	}
+}
+
+func Test_shouldPrerenderRejectsSpoofedHosts(t *testing.T) {
+	options := NewOptions()
+	options.AllowedHosts = []string{"www.example.com"}
+	options.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	p := options.NewPrerender()
+
+	tests := []struct {
+		name       string
+		host       string
+		remoteAddr string
+		headers    map[string]string
+		prerender  bool
+	}{
+		{"allowed host", "www.example.com", "203.0.113.9:1234", nil, true},
+		{"spoofed host", "evil.example", "203.0.113.9:1234", nil, false},
+		{"spoofed forwarded host from untrusted peer", "www.example.com", "203.0.113.9:1234", map[string]string{"X-Forwarded-Host": "evil.example"}, true},
+		{"spoofed forwarded host through trusted proxy", "www.example.com", "10.0.0.1:1234", map[string]string{"X-Forwarded-Host": "evil.example"}, false},
+		{"spoofed rfc 7239 host through trusted proxy", "www.example.com", "10.0.0.1:1234", map[string]string{"Forwarded": "host=evil.example"}, false},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Host = test.host
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+
+		if p.ShouldPrerender(req) != test.prerender {
+			t.Errorf("%s: ShouldPrerender should be %v", test.name, test.prerender)
+		}
+	}
+}
+
+func Test_buildURLWithCanonicalHost(t *testing.T) {
+	options := NewOptions()
+	options.PrerenderURL, _ = url.Parse("https://service.headless-render-api.com/")
+	options.AllowedHosts = []string{"example.com", "www.example.com"}
+	options.CanonicalHost = "www.example.com"
+
+	req, _ := http.NewRequest("GET", "/about", nil)
+	req.Host = "example.com"
+
+	if url := options.NewPrerender().buildURL(httpRequest{req}); url != "https://service.headless-render-api.com/http://www.example.com/about" {
+		t.Errorf("unexpected URL %q", url)
+	}
+}
@@ -0,0 +1,116 @@
+// Package adaptertest holds the tests every framework adapter must pass.
+package adaptertest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+// Server serves a GET request for url, with userAgent unless it is empty,
+// through an adapter's middleware built from options, and returns the status
+// code and body written. The middleware must sit in front of a handler
+// answering "next handler" for "/", "/style.woff" and "/users/{id}".
+type Server func(t *testing.T, options *prerendercloud.Options, url, userAgent string) (int, string)
+
+// HTTPServer returns a Server for adapters exposed as an http.Handler.
+func HTTPServer(newHandler func(options *prerendercloud.Options) http.Handler) Server {
+	return func(t *testing.T, options *prerendercloud.Options, url, userAgent string) (int, string) {
+		res := Serve(newHandler(options), url, userAgent)
+		return res.Code, res.Body.String()
+	}
+}
+
+// Serve serves a GET request for url through h, with userAgent unless it is
+// empty.
+func Serve(h http.Handler, url, userAgent string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", url, nil)
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	h.ServeHTTP(res, req)
+	return res
+}
+
+// Run runs the shared adapter tests against serve. The calling package must
+// have activated httpmock; adapters built on fasthttp must also pass the
+// options to FastHTTPStandIn.
+func Run(t *testing.T, serve Server) {
+	tests := []struct {
+		name       string
+		url        string
+		userAgent  string
+		upstream   httpmock.Responder
+		statusCode int
+		body       string
+		message    string
+	}{
+		{
+			name:    "NoUserAgentRequest",
+			url:     "http://www.example.com/",
+			body:    "next handler",
+			message: "prerender.cloud should not have been called when there is no user-agent",
+		},
+		{
+			name:       "WithUserAgentRequest",
+			url:        "http://www.example.com/",
+			userAgent:  "example-user-agent",
+			upstream:   httpmock.NewStringResponder(201, `prerendered response`),
+			statusCode: 201,
+			body:       "prerendered response",
+			message:    "prerender.cloud should have been called when the request had a user-agent present, preserving its statusCode",
+		},
+		{
+			name:      "WithPrerendercloudUserAgentRequest",
+			url:       "http://www.example.com/",
+			userAgent: "prerendercloud",
+			body:      "next handler",
+			message:   "prerender.cloud should not have been called when the request had the prerendercloud user-agent present",
+		},
+		{
+			name:      "WithUserAgentAndStaticResourceRequest",
+			url:       "http://www.example.com/style.woff",
+			userAgent: "example-user-agent",
+			body:      "next handler",
+			message:   "prerender.cloud should not have been called for static resource",
+		},
+		{
+			name:       "WithServerErrorAndNextHandler",
+			url:        "http://www.example.com/users/500",
+			userAgent:  "example-user-agent",
+			upstream:   httpmock.NewStringResponder(500, `server error`),
+			statusCode: 200,
+			body:       "next handler",
+			message:    "middleware should return 200 response from next handler when server returns 500",
+		},
+		{
+			name:      "WithNetworkErrorAndNextHandler",
+			url:       "http://www.example.com/users/unreachable",
+			userAgent: "example-user-agent",
+			upstream:  httpmock.NewErrorResponder(errors.New("dial tcp: lookup service.headless-render-api.com: no such host")),
+			body:      "next handler",
+			message:   "middleware should fall through to next handler when prerender.cloud is unreachable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.upstream != nil {
+				httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/"+test.url, test.upstream)
+			}
+
+			statusCode, body := serve(t, prerendercloud.NewOptions(), test.url, test.userAgent)
+
+			if (test.statusCode != 0 && statusCode != test.statusCode) || body != test.body {
+				fmt.Printf("actual StatusCode %#v, response %#v\n", statusCode, body)
+				t.Error("Error, " + test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package adaptertest
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+// FastHTTPStandIn points options at an in-memory server standing in for the
+// service, for adapters built on fasthttp, whose requests to the service
+// don't go through net/http and so can't be caught by httpmock. The server
+// answers with the responders registered with httpmock, and drops the
+// connection when they return an error.
+func FastHTTPStandIn(t *testing.T, options *prerendercloud.Options) {
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { ln.Close() })
+
+	go (&fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			req, _ := http.NewRequest("GET", "https://service.headless-render-api.com"+string(ctx.RequestURI()), nil)
+			ctx.Request.Header.VisitAll(func(key, value []byte) {
+				req.Header.Add(string(key), string(value))
+			})
+
+			res, err := httpmock.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				ctx.HijackSetNoResponse(true)
+				ctx.Hijack(func(net.Conn) {})
+				return
+			}
+			defer res.Body.Close()
+
+			body, _ := ioutil.ReadAll(res.Body)
+			ctx.SetStatusCode(res.StatusCode)
+			ctx.SetBody(body)
+		},
+	}).Serve(ln)
+
+	options.PrerenderURL, _ = url.Parse("http://service.test/")
+	options.FastHTTPClient = prerendercloud.NewFastHTTPClient()
+	options.FastHTTPClient.Dial = func(addr string) (net.Conn, error) { return ln.Dial() }
+}
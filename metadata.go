@@ -0,0 +1,54 @@
+package prerendercloud
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// PageMetadata is the metadata of a rendered page, as returned by Metadata.
+type PageMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	H1          string `json:"h1"`
+	Canonical   string `json:"canonical"`
+
+	// StatusCode is the status the page rendered with, which may come from a
+	// <meta name="prerender-status-code"> tag.
+	StatusCode int `json:"statusCode"`
+
+	OGTitle       string `json:"ogTitle"`
+	OGDescription string `json:"ogDescription"`
+	OGImage       string `json:"ogImage"`
+	OGType        string `json:"ogType"`
+	OGURL         string `json:"ogUrl"`
+	OGSiteName    string `json:"ogSiteName"`
+
+	TwitterCard        string `json:"twitterCard"`
+	TwitterTitle       string `json:"twitterTitle"`
+	TwitterDescription string `json:"twitterDescription"`
+	TwitterImage       string `json:"twitterImage"`
+	TwitterSite        string `json:"twitterSite"`
+}
+
+// Metadata renders url with the service and returns its title, description,
+// Open Graph and Twitter card data. Failures are returned as an *Error.
+func (p *Prerender) Metadata(ctx context.Context, url string) (*PageMetadata, error) {
+	req, err := p.newAPIRequest(ctx, "metadata", url)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.doAPI(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var metadata PageMetadata
+	if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return nil, transportError(err, DecodeError)
+	}
+
+	return &metadata, nil
+}
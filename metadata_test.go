@@ -0,0 +1,58 @@
+package prerendercloud
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func Test_Metadata(t *testing.T) {
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/https://example.org/post" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Prerender-Token") != "secret" {
+			t.Error("expected the token to be sent")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"title": "A post",
+			"description": "About things",
+			"statusCode": 200,
+			"ogTitle": "A post | Example",
+			"ogImage": "https://example.org/post.png",
+			"ogType": "article",
+			"twitterCard": "summary_large_image",
+			"somethingNew": true
+		}`))
+	})
+
+	metadata, err := p.Metadata(context.Background(), "https://example.org/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := PageMetadata{
+		Title:       "A post",
+		Description: "About things",
+		StatusCode:  200,
+		OGTitle:     "A post | Example",
+		OGImage:     "https://example.org/post.png",
+		OGType:      "article",
+		TwitterCard: "summary_large_image",
+	}
+	if *metadata != expected {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+}
+
+func Test_MetadataDecodeError(t *testing.T) {
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>not json</html>"))
+	})
+
+	if _, err := p.Metadata(context.Background(), "https://example.org/"); !IsErrorKind(err, DecodeError) {
+		t.Errorf("expected a DecodeError, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package prerendercloud
+
+import (
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Middleware wraps next in the standard net/http middleware form used by
+// net/http, chi and gorilla/mux. Requests that shouldn't be prerendered, and
+// prerenders that fail, are served by next.
+func (p *Prerender) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		p.ServeHTTP(rw, r, next.ServeHTTP)
+	})
+}
+
+// Handler returns an http.Handler that prerenders requests on its own, like
+// PreRenderHandler without a next handler: requests that shouldn't be
+// prerendered get a 404 Not Found, upstream 5xx responses are passed through
+// and other failures produce a 502 Bad Gateway.
+func (p *Prerender) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !p.ShouldPrerender(r) {
+			http.NotFound(rw, r)
+			return
+		}
+		p.PreRenderHandler(rw, r, nil)
+	})
+}
+
+// FastHTTPMiddleware wraps next so requests that shouldn't be prerendered, and
+// prerenders that fail, are served by next. PreRenderHandlerFastHttp doesn't
+// touch ctx until the render has succeeded, so next always gets ctx as it
+// was. Requests cancelled by server shutdown are dropped.
+func (p *Prerender) FastHTTPMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !p.ShouldPrerenderFastHttp(ctx) {
+			next(ctx)
+			return
+		}
+
+		err := p.PreRenderHandlerFastHttp(ctx)
+		if err == nil || IsErrorKind(err, CanceledError) {
+			return
+		}
+
+		next(ctx)
+	}
+}
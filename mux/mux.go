@@ -0,0 +1,44 @@
+// Package mux plugs a Prerender into a gorilla/mux router, making mux path
+// templates such as "/users/{id}" available to Route.Pattern.
+package mux
+
+import (
+	"net/http"
+
+	gorillamux "github.com/gorilla/mux"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+)
+
+// Middleware returns mux middleware for p, for use with Router.Use. mux only
+// runs middleware for requests that matched a route, so wrap the router with
+// Prerender.Middleware instead to prerender every path. Requests that
+// shouldn't be prerendered, and prerenders that fail, are served by the
+// matched route.
+func Middleware(p *prerendercloud.Prerender) gorillamux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		handler := p.Middleware(next)
+
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if pattern := RoutePattern(r); pattern != "" {
+				r = r.WithContext(prerendercloud.WithRoutePattern(r.Context(), pattern))
+			}
+			handler.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// RoutePattern returns the path template of the mux route r matched, such as
+// "/users/{id}", or "" when r wasn't routed by mux or the route has no path
+// template.
+func RoutePattern(r *http.Request) string {
+	route := gorillamux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+
+	pattern, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return pattern
+}
@@ -0,0 +1,95 @@
+package mux
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	gorillamux "github.com/gorilla/mux"
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+	"github.com/sanfrancesco/prerendercloud-golang/internal/adaptertest"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestMain(m *testing.M) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	os.Exit(m.Run())
+}
+
+func newRouter(options *prerendercloud.Options) *gorillamux.Router {
+	next := func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(res, "next handler")
+	}
+
+	r := gorillamux.NewRouter()
+	r.Use(Middleware(options.NewPrerender()))
+	r.HandleFunc("/", next)
+	r.HandleFunc("/style.woff", next)
+	r.HandleFunc("/users/{id}", next)
+	blog := r.PathPrefix("/blog").Subrouter()
+	blog.HandleFunc("/{slug}", next)
+	return r
+}
+
+func Test_Middleware(t *testing.T) {
+	adaptertest.Run(t, adaptertest.HTTPServer(func(options *prerendercloud.Options) http.Handler {
+		return newRouter(options)
+	}))
+}
+
+func Test_RoutePattern(t *testing.T) {
+	options := prerendercloud.NewOptions()
+	botsOnly := true
+	options.Routes = []prerendercloud.Route{
+		{Pattern: "/users/{id}", BotsOnly: &botsOnly},
+		{Pattern: "/blog/{slug}", BotsOnly: &botsOnly},
+	}
+	r := newRouter(options)
+
+	for _, url := range []string{"http://www.example.com/users/42", "http://www.example.com/blog/hello"} {
+		httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/"+url, httpmock.NewStringResponder(200, `prerendered response`))
+
+		res := adaptertest.Serve(r, url, "example-user-agent")
+		if string(res.Body.Bytes()) != "next handler" {
+			fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+			t.Errorf("Error, the route pattern's BotsOnly should skip prerendering %s for a non-bot", url)
+		}
+
+		res = adaptertest.Serve(r, url, "Twitterbot/1.0")
+		if string(res.Body.Bytes()) != "prerendered response" {
+			fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+			t.Errorf("Error, the route pattern's BotsOnly should prerender %s for a bot", url)
+		}
+	}
+}
+
+func Test_RoutePatternWithRenderOptions(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/users/7", func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Prerender-Wait-Extra-Long") != "true" {
+			return httpmock.NewStringResponse(200, "without route options"), nil
+		}
+		return httpmock.NewStringResponse(200, "with route options"), nil
+	})
+
+	options := prerendercloud.NewOptions()
+	options.Routes = []prerendercloud.Route{
+		{Pattern: "/users/{id}", Render: &prerendercloud.RenderOptions{WaitExtraLong: true}},
+	}
+
+	res := adaptertest.Serve(newRouter(options), "http://www.example.com/users/7", "example-user-agent")
+
+	if string(res.Body.Bytes()) != "with route options" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, the render options of the matching route pattern should be sent")
+	}
+}
+
+func Test_RoutePatternOutsideMux(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://www.example.com/users/42", nil)
+
+	if pattern := RoutePattern(req); pattern != "" {
+		t.Errorf("Error, expected no route pattern outside mux, got %q", pattern)
+	}
+}
@@ -1,11 +1,15 @@
 package negroni
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
 	"gopkg.in/jarcoal/httpmock.v1"
@@ -153,3 +157,326 @@ func Test_WithServerErrorAndNoNextMiddleware(t *testing.T) {
 		t.Error("Error, middleware should return response from next middleware when server returns 500")
 	}
 }
+
+func Test_WithNetworkErrorAndNextMiddleware(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/unreachable", httpmock.NewErrorResponder(errors.New("dial tcp: lookup service.headless-render-api.com: no such host")))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/unreachable", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().ServeHTTP(res, req, func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(res, "next middleware")
+	})
+
+	if string(res.Body.Bytes()) != "next middleware" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, middleware should fall through to next middleware when prerender.cloud is unreachable")
+	}
+}
+
+func Test_WithCorruptGzipAndNextMiddleware(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/corrupt", func(req *http.Request) (*http.Response, error) {
+		res := httpmock.NewStringResponse(200, "not gzip")
+		res.Header.Set("Content-Encoding", "gzip")
+		return res, nil
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/corrupt", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().ServeHTTP(res, req, func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(res, "next middleware")
+	})
+
+	if string(res.Body.Bytes()) != "next middleware" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, middleware should fall through to next middleware when the response can't be decoded")
+	}
+}
+
+func Test_PreRenderReturnsTypedError(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/broken", httpmock.NewStringResponder(503, `unavailable`))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/broken", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	err := prerendercloud.NewOptions().NewPrerender().PreRender(res, req)
+
+	if !prerendercloud.IsErrorKind(err, prerendercloud.UpstreamError) {
+		fmt.Printf("actual error %#v\n", err)
+		t.Error("Error, PreRender should return an UpstreamError when server returns 503")
+	}
+
+	if len(res.Body.Bytes()) > 0 {
+		t.Error("Error, PreRender should not write a response when it returns an error")
+	}
+}
+
+func Test_WithTimeoutAndNextMiddleware(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/slow", func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/slow", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	options := prerendercloud.NewOptions()
+	options.Timeout = 10 * time.Millisecond
+
+	err := options.NewPrerender().PreRender(res, req)
+
+	if !prerendercloud.IsErrorKind(err, prerendercloud.TimeoutError) {
+		fmt.Printf("actual error %#v\n", err)
+		t.Error("Error, PreRender should return a TimeoutError when the render takes longer than Options.Timeout")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_WithCustomHTTPClient(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/custom-client", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	options := prerendercloud.NewOptions()
+	options.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(200, "custom client"), nil
+	})}
+
+	options.NewPrerender().ServeHTTP(res, req, nil)
+
+	if string(res.Body.Bytes()) != "custom client" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, upstream requests should be made with Options.HTTPClient")
+	}
+}
+
+func Test_WithCanceledRequest(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/canceled", func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/canceled", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	nextCalled := false
+	prerendercloud.NewOptions().NewPrerender().ServeHTTP(res, req, func(res http.ResponseWriter, req *http.Request) {
+		nextCalled = true
+	})
+
+	if nextCalled {
+		t.Error("Error, next middleware should not be called when the client disconnected")
+	}
+
+	if len(res.Body.Bytes()) > 0 {
+		t.Error("Error, nothing should be written when the client disconnected")
+	}
+
+	err := prerendercloud.NewOptions().NewPrerender().PreRender(res, req)
+	if !prerendercloud.IsErrorKind(err, prerendercloud.CanceledError) {
+		fmt.Printf("actual error %#v\n", err)
+		t.Error("Error, PreRender should return a CanceledError when the request context is cancelled")
+	}
+}
+
+func Test_WithCache(t *testing.T) {
+	calls := 0
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/cached", func(req *http.Request) (*http.Response, error) {
+		calls++
+		return httpmock.NewStringResponse(200, "prerendered response"), nil
+	})
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/no-store", func(req *http.Request) (*http.Response, error) {
+		calls++
+		res := httpmock.NewStringResponse(200, "prerendered response")
+		res.Header.Set("Cache-Control", "no-store")
+		return res, nil
+	})
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/server-error", func(req *http.Request) (*http.Response, error) {
+		calls++
+		return httpmock.NewStringResponse(503, "server error"), nil
+	})
+
+	options := prerendercloud.NewOptions()
+	options.Cache = prerendercloud.NewLRUCache(1<<20, time.Minute)
+	prerender := options.NewPrerender()
+
+	for _, path := range []string{"/cached", "/no-store", "/server-error"} {
+		calls = 0
+
+		for i := 0; i < 2; i++ {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://www.example.com"+path, nil)
+			req.Header.Set("User-Agent", "example-user-agent")
+			prerender.ServeHTTP(res, req, nil)
+		}
+
+		expected := 2
+		if path == "/cached" {
+			expected = 1
+		}
+
+		if calls != expected {
+			fmt.Printf("actual calls for %s %#v\n", path, calls)
+			t.Errorf("Error, expected %d upstream calls for %s", expected, path)
+		}
+	}
+}
+
+type mapCache struct {
+	sync.Mutex
+	entries map[string]*prerendercloud.CachedResponse
+}
+
+func (c *mapCache) Get(key string) (*prerendercloud.CachedResponse, bool) {
+	c.Lock()
+	defer c.Unlock()
+	res, ok := c.entries[key]
+	return res, ok
+}
+
+func (c *mapCache) Set(key string, res *prerendercloud.CachedResponse) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = res
+}
+
+func Test_WithStaleWhileRevalidate(t *testing.T) {
+	key := "https://service.headless-render-api.com/http://www.example.com/stale-while-revalidate"
+	refreshed := make(chan struct{})
+	httpmock.RegisterResponder("GET", key, func(req *http.Request) (*http.Response, error) {
+		defer close(refreshed)
+		return httpmock.NewStringResponse(200, "fresh response"), nil
+	})
+
+	cache := &mapCache{entries: map[string]*prerendercloud.CachedResponse{
+		key: {StatusCode: 200, Header: http.Header{}, Body: []byte("stale response"), Expires: time.Now().Add(-time.Second)},
+	}}
+
+	options := prerendercloud.NewOptions()
+	options.Cache = cache
+	options.StaleWhileRevalidate = time.Minute
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/stale-while-revalidate", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+	options.NewPrerender().ServeHTTP(res, req, nil)
+
+	if string(res.Body.Bytes()) != "stale response" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, stale response should be served while it is revalidated")
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("Error, stale response should be refreshed in the background")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if cached, _ := cache.Get(key); string(cached.Body) == "fresh response" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Error, refreshed response should be stored in the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_WithStaleIfError(t *testing.T) {
+	key := "https://service.headless-render-api.com/http://www.example.com/stale-if-error"
+	httpmock.RegisterResponder("GET", key, httpmock.NewStringResponder(503, `server error`))
+
+	options := prerendercloud.NewOptions()
+	options.Cache = &mapCache{entries: map[string]*prerendercloud.CachedResponse{
+		key: {StatusCode: 200, Header: http.Header{}, Body: []byte("last good response"), Expires: time.Now().Add(-time.Second)},
+	}}
+	options.StaleIfError = time.Minute
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/stale-if-error", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+	options.NewPrerender().ServeHTTP(res, req, func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(res, "next middleware")
+	})
+
+	if string(res.Body.Bytes()) != "last good response" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, last good response should be served when server returns 503")
+	}
+}
+
+func Test_WithExcludedPath(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/api/users", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	options := prerendercloud.NewOptions()
+	options.ExcludePaths = []prerendercloud.PathPattern{prerendercloud.Glob("/api/**")}
+	options.NewPrerender().ServeHTTP(res, req, nil)
+
+	if len(res.Body.Bytes()) > 0 {
+		t.Error("Error, prerender.cloud should not have been called for an excluded path")
+	}
+}
+
+func Test_WithOpenCircuitBreaker(t *testing.T) {
+	var calls int
+	var mu sync.Mutex
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/degraded", func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return httpmock.NewStringResponse(503, "server error"), nil
+	})
+
+	options := prerendercloud.NewOptions()
+	options.CircuitBreaker = prerendercloud.NewCircuitBreaker(0.5, time.Minute)
+	options.CircuitBreaker.MinRequests = 2
+	prerenderCloud := options.NewPrerender()
+
+	for i := 0; i < 3; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://www.example.com/degraded", nil)
+		req.Header.Set("User-Agent", "example-user-agent")
+
+		prerenderCloud.ServeHTTP(res, req, func(res http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(res, "next middleware")
+		})
+
+		if string(res.Body.Bytes()) != "next middleware" {
+			fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+			t.Error("Error, middleware should fall through to next middleware when the render service is failing")
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("Error, expected the open circuit to stop upstream calls after 2 failures, got %d calls", calls)
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/degraded", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	err := prerenderCloud.PreRender(res, req)
+	if !prerendercloud.IsErrorKind(err, prerendercloud.CircuitOpenError) {
+		fmt.Printf("actual error %#v\n", err)
+		t.Error("Error, PreRender should return a CircuitOpenError while the circuit is open")
+	}
+}
@@ -0,0 +1,219 @@
+package nethttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	prerendercloud "github.com/sanfrancesco/prerendercloud-golang"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestMain(m *testing.M) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	os.Exit(m.Run())
+}
+
+var nextHandler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(res, "next handler")
+})
+
+func Test_MiddlewareNoUserAgentRequest(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+
+	prerendercloud.NewOptions().NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "next handler" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, next handler should serve requests without a user-agent")
+	}
+}
+
+func Test_MiddlewareWithUserAgentRequest(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/", httpmock.NewStringResponder(201, `prerendered response`))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if res.Result().StatusCode != 201 {
+		fmt.Printf("actual StatusCode %#v\n", res.Result().StatusCode)
+		t.Error("expected prerender.cloud statusCode to be preserved")
+	}
+
+	if string(res.Body.Bytes()) != "prerendered response" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, prerender.cloud should have been called when the request had a user-agent present")
+	}
+}
+
+func Test_MiddlewareWithPrerendercloudUserAgentRequest(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "prerendercloud")
+
+	prerendercloud.NewOptions().NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "next handler" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, prerender.cloud should not have been called when the request had the prerendercloud user-agent present")
+	}
+}
+
+func Test_MiddlewareWithUserAgentAndStaticResourceRequest(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/style.woff", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "next handler" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, prerender.cloud should not have been called for static resource")
+	}
+}
+
+func Test_MiddlewareWithServerError(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/broken", httpmock.NewStringResponder(500, `server error`))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/broken", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if res.Result().StatusCode != 200 {
+		fmt.Printf("actual StatusCode %#v\n", res.Result().StatusCode)
+		t.Error("Error, middleware should return 200 response when server returns 500")
+	}
+
+	if string(res.Body.Bytes()) != "next handler" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, middleware should return response from next handler when server returns 500")
+	}
+}
+
+func Test_MiddlewareWithNetworkError(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/unreachable", httpmock.NewErrorResponder(errors.New("dial tcp: lookup service.headless-render-api.com: no such host")))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/unreachable", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "next handler" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, middleware should fall through to next handler when prerender.cloud is unreachable")
+	}
+}
+
+func Test_MiddlewareWithExcludedPath(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/api/users", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	options := prerendercloud.NewOptions()
+	options.ExcludePaths = []prerendercloud.PathPattern{prerendercloud.Glob("/api/**")}
+	options.NewPrerender().Middleware(nextHandler).ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "next handler" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, prerender.cloud should not have been called for an excluded path")
+	}
+}
+
+func Test_MiddlewareInServeMux(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/", httpmock.NewStringResponder(200, `prerendered response`))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", nextHandler)
+	handler := prerendercloud.NewOptions().NewPrerender().Middleware(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+	handler.ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "prerendered response" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, middleware wrapping a ServeMux should serve the prerendered response")
+	}
+}
+
+func Test_HandlerWithUserAgentRequest(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/deep/path.html", httpmock.NewStringResponder(200, `prerendered response`))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/deep/path.html", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Handler().ServeHTTP(res, req)
+
+	if string(res.Body.Bytes()) != "prerendered response" {
+		fmt.Printf("actual response %#v\n", string(res.Body.Bytes()))
+		t.Error("Error, handler should serve the prerendered response")
+	}
+}
+
+func Test_HandlerNoUserAgentRequest(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+
+	prerendercloud.NewOptions().NewPrerender().Handler().ServeHTTP(res, req)
+
+	if res.Result().StatusCode != 404 {
+		fmt.Printf("actual StatusCode %#v\n", res.Result().StatusCode)
+		t.Error("Error, handler should return 404 when there is no user-agent")
+	}
+}
+
+func Test_HandlerPostRequest(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Handler().ServeHTTP(res, req)
+
+	if res.Result().StatusCode != 404 {
+		fmt.Printf("actual StatusCode %#v\n", res.Result().StatusCode)
+		t.Error("Error, handler should return 404 for requests that aren't prerendered")
+	}
+}
+
+func Test_HandlerWithServerError(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/unavailable", httpmock.NewStringResponder(503, `server error`))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/unavailable", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Handler().ServeHTTP(res, req)
+
+	if res.Result().StatusCode != 503 {
+		fmt.Printf("actual StatusCode %#v\n", res.Result().StatusCode)
+		t.Error("Error, handler should return server's 503 response")
+	}
+}
+
+func Test_HandlerWithNetworkError(t *testing.T) {
+	httpmock.RegisterResponder("GET", "https://service.headless-render-api.com/http://www.example.com/down", httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://www.example.com/down", nil)
+	req.Header.Set("User-Agent", "example-user-agent")
+
+	prerendercloud.NewOptions().NewPrerender().Handler().ServeHTTP(res, req)
+
+	if res.Result().StatusCode != 502 {
+		fmt.Printf("actual StatusCode %#v\n", res.Result().StatusCode)
+		t.Error("Error, handler should return 502 when prerender.cloud is unreachable")
+	}
+}
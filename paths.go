@@ -0,0 +1,78 @@
+package prerendercloud
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PathPattern matches request paths for Options.IncludePaths and
+// Options.ExcludePaths. Create one with Glob or Regexp.
+type PathPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Glob returns a PathPattern matching whole paths where "*" matches any run
+// of characters except "/", "**" matches anything including "/", and "?"
+// matches a single character other than "/". For example "/api/**" matches
+// every path below /api/ and "/*.php" matches PHP files at the root.
+func Glob(pattern string) PathPattern {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	expr.WriteString("$")
+	return PathPattern{pattern: pattern, re: regexp.MustCompile(expr.String())}
+}
+
+// Regexp returns a PathPattern matching paths against the regular expression
+// expr, which is not anchored unless it says so. It panics if expr doesn't
+// compile.
+func Regexp(expr string) PathPattern {
+	return PathPattern{pattern: expr, re: regexp.MustCompile(expr)}
+}
+
+// Match reports whether path matches the pattern.
+func (pp PathPattern) Match(path string) bool {
+	return pp.re != nil && pp.re.MatchString(path)
+}
+
+// String returns the pattern as it was given to Glob or Regexp, or "" for
+// the zero PathPattern.
+func (pp PathPattern) String() string {
+	return pp.pattern
+}
+
+// MatchPathRule reports whether path may be prerendered according to
+// IncludePaths and ExcludePaths, along with the rule that decided it. Exclude
+// rules win over include rules; when IncludePaths is empty every path not
+// excluded is allowed. The returned rule is the zero PathPattern when no rule
+// matched.
+func (o *Options) MatchPathRule(path string) (rule PathPattern, allowed bool) {
+	for _, pattern := range o.ExcludePaths {
+		if pattern.Match(path) {
+			return pattern, false
+		}
+	}
+
+	for _, pattern := range o.IncludePaths {
+		if pattern.Match(path) {
+			return pattern, true
+		}
+	}
+
+	return PathPattern{}, len(o.IncludePaths) == 0
+}
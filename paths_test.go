@@ -0,0 +1,57 @@
+package prerendercloud
+
+import "testing"
+
+func Test_Glob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/healthz", "/healthz", true},
+		{"/healthz", "/healthz/live", false},
+		{"/api/*", "/api/users", true},
+		{"/api/*", "/api/users/1", false},
+		{"/api/**", "/api/users/1", true},
+		{"/api/**", "/apiary", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/wp/index.php", false},
+		{"/v?/users", "/v2/users", true},
+		{"/a.b", "/axb", false},
+	}
+
+	for _, test := range tests {
+		if Glob(test.pattern).Match(test.path) != test.match {
+			t.Errorf("Glob(%q).Match(%q) should be %v", test.pattern, test.path, test.match)
+		}
+	}
+}
+
+func Test_MatchPathRule(t *testing.T) {
+	options := &Options{
+		IncludePaths: []PathPattern{Glob("/products/**"), Regexp("^/blog(/|$)")},
+		ExcludePaths: []PathPattern{Glob("/products/*/edit")},
+	}
+
+	tests := []struct {
+		path    string
+		rule    string
+		allowed bool
+	}{
+		{"/products/1", "/products/**", true},
+		{"/products/1/edit", "/products/*/edit", false},
+		{"/blog", "^/blog(/|$)", true},
+		{"/blogroll", "", false},
+	}
+
+	for _, test := range tests {
+		rule, allowed := options.MatchPathRule(test.path)
+		if rule.String() != test.rule || allowed != test.allowed {
+			t.Errorf("%s: expected (%q, %v), got (%q, %v)", test.path, test.rule, test.allowed, rule, allowed)
+		}
+	}
+
+	if _, allowed := (&Options{}).MatchPathRule("/anything"); !allowed {
+		t.Error("every path should be allowed without rules")
+	}
+}
@@ -0,0 +1,185 @@
+package prerendercloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PDFOptions controls a PDF. Zero values leave the service defaults in place.
+type PDFOptions struct {
+	// PageSize is a named paper size: "Letter", "Legal", "Tabloid", "Ledger"
+	// or "A0" through "A6". Leave it empty to use PageWidth and PageHeight.
+	PageSize string
+
+	// PageWidth and PageHeight set a custom paper size as CSS lengths, such
+	// as "8.5in" or "210mm".
+	PageWidth  string
+	PageHeight string
+
+	// Margins are CSS lengths.
+	MarginTop    string
+	MarginRight  string
+	MarginBottom string
+	MarginLeft   string
+
+	Landscape       bool
+	PrintBackground bool
+
+	// Scale zooms the page rendering, from 0.1 to 2.
+	Scale float64
+
+	// PageRanges selects pages to print, such as "1-3, 5".
+	PageRanges string
+
+	// DisplayHeaderFooter prints HeaderTemplate and FooterTemplate, HTML
+	// snippets that may use the date, title, url, pageNumber and totalPages
+	// classes, on every page.
+	DisplayHeaderFooter bool
+	HeaderTemplate      string
+	FooterTemplate      string
+}
+
+var pdfPageSizes = map[string]bool{
+	"letter": true, "legal": true, "tabloid": true, "ledger": true,
+	"a0": true, "a1": true, "a2": true, "a3": true, "a4": true, "a5": true, "a6": true,
+}
+
+var cssLengthRegex = regexp.MustCompile(`^\d+(\.\d+)?(px|in|cm|mm)?$`)
+
+// Validate reports whether the options can be sent to the service.
+func (po *PDFOptions) Validate() error {
+	if po.PageSize != "" && !pdfPageSizes[strings.ToLower(po.PageSize)] {
+		return fmt.Errorf("prerendercloud: unsupported PageSize %q", po.PageSize)
+	}
+
+	if po.PageSize != "" && (po.PageWidth != "" || po.PageHeight != "") {
+		return errors.New("prerendercloud: use either PageSize or PageWidth and PageHeight")
+	}
+
+	lengths := []struct{ name, value string }{
+		{"PageWidth", po.PageWidth},
+		{"PageHeight", po.PageHeight},
+		{"MarginTop", po.MarginTop},
+		{"MarginRight", po.MarginRight},
+		{"MarginBottom", po.MarginBottom},
+		{"MarginLeft", po.MarginLeft},
+	}
+	for _, length := range lengths {
+		if length.value != "" && !cssLengthRegex.MatchString(length.value) {
+			return fmt.Errorf("prerendercloud: %s %q is not a CSS length", length.name, length.value)
+		}
+	}
+
+	if po.Scale != 0 && (po.Scale < 0.1 || po.Scale > 2) {
+		return fmt.Errorf("prerendercloud: Scale %v out of range 0.1-2", po.Scale)
+	}
+
+	if !po.DisplayHeaderFooter && (po.HeaderTemplate != "" || po.FooterTemplate != "") {
+		return errors.New("prerendercloud: HeaderTemplate and FooterTemplate require DisplayHeaderFooter")
+	}
+
+	// templates travel as header values
+	for _, value := range []string{po.PageRanges, po.HeaderTemplate, po.FooterTemplate} {
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.New("prerendercloud: PDF options can't contain line breaks")
+		}
+	}
+
+	return nil
+}
+
+func (po *PDFOptions) setHeaders(header http.Header) {
+	values := []struct{ header, value string }{
+		{"Prerender-Pdf-Page-Size", po.PageSize},
+		{"Prerender-Pdf-Page-Width", po.PageWidth},
+		{"Prerender-Pdf-Page-Height", po.PageHeight},
+		{"Prerender-Pdf-Margin-Top", po.MarginTop},
+		{"Prerender-Pdf-Margin-Right", po.MarginRight},
+		{"Prerender-Pdf-Margin-Bottom", po.MarginBottom},
+		{"Prerender-Pdf-Margin-Left", po.MarginLeft},
+		{"Prerender-Pdf-Page-Ranges", po.PageRanges},
+		{"Prerender-Pdf-Header-Template", po.HeaderTemplate},
+		{"Prerender-Pdf-Footer-Template", po.FooterTemplate},
+	}
+	for _, v := range values {
+		if v.value != "" {
+			header.Set(v.header, v.value)
+		}
+	}
+
+	flags := []struct {
+		set    bool
+		header string
+	}{
+		{po.Landscape, "Prerender-Pdf-Landscape"},
+		{po.PrintBackground, "Prerender-Pdf-Print-Background"},
+		{po.DisplayHeaderFooter, "Prerender-Pdf-Display-Header-Footer"},
+	}
+	for _, flag := range flags {
+		if flag.set {
+			header.Set(flag.header, "true")
+		}
+	}
+
+	if po.Scale != 0 {
+		header.Set("Prerender-Pdf-Scale", strconv.FormatFloat(po.Scale, 'f', -1, 64))
+	}
+}
+
+// PDF renders url as a PDF with the service and streams it to w, returning
+// the number of bytes written. opts may be nil. Failures talking to the
+// service are returned as an *Error; an error from w is returned as is. If
+// the transfer fails midway, w has already received part of the document.
+func (p *Prerender) PDF(ctx context.Context, url string, w io.Writer, opts *PDFOptions) (int64, error) {
+	if opts == nil {
+		opts = &PDFOptions{}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return 0, &Error{Kind: RequestError, Err: err}
+	}
+
+	req, err := p.newAPIRequest(ctx, "pdf", url)
+	if err != nil {
+		return 0, err
+	}
+	opts.setHeaders(req.Header)
+
+	res, err := p.doAPI(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	dst := &trackingWriter{w: w}
+	n, err := io.Copy(dst, res.Body)
+	if err != nil {
+		if dst.err != nil {
+			return n, dst.err
+		}
+		return n, transportError(err, NetworkError)
+	}
+
+	return n, nil
+}
+
+// trackingWriter remembers write errors so they can be told apart from read
+// errors after io.Copy.
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(b []byte) (int, error) {
+	n, err := t.w.Write(b)
+	if err != nil {
+		t.err = err
+	}
+	return n, err
+}
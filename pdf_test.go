@@ -0,0 +1,100 @@
+package prerendercloud
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_PDF(t *testing.T) {
+	document := strings.Repeat("%PDF-1.7 ", 10000)
+
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pdf/https://example.org/invoices/1" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		expected := map[string]string{
+			"X-Prerender-Token":                   "secret",
+			"Prerender-Pdf-Page-Size":             "A4",
+			"Prerender-Pdf-Margin-Top":            "1cm",
+			"Prerender-Pdf-Margin-Bottom":         "1.5cm",
+			"Prerender-Pdf-Landscape":             "true",
+			"Prerender-Pdf-Print-Background":      "true",
+			"Prerender-Pdf-Display-Header-Footer": "true",
+			"Prerender-Pdf-Footer-Template":       `<span class="pageNumber"></span>`,
+			"Prerender-Pdf-Scale":                 "0.8",
+		}
+		for name, value := range expected {
+			if r.Header.Get(name) != value {
+				t.Errorf("expected %s: %s, got %q", name, value, r.Header.Get(name))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte(document))
+	})
+
+	var buf bytes.Buffer
+	n, err := p.PDF(context.Background(), "https://example.org/invoices/1", &buf, &PDFOptions{
+		PageSize:            "A4",
+		MarginTop:           "1cm",
+		MarginBottom:        "1.5cm",
+		Landscape:           true,
+		PrintBackground:     true,
+		DisplayHeaderFooter: true,
+		FooterTemplate:      `<span class="pageNumber"></span>`,
+		Scale:               0.8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(document)) || buf.String() != document {
+		t.Errorf("expected the whole document to be streamed, got %d bytes", n)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func Test_PDFWriterError(t *testing.T) {
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.7"))
+	})
+
+	_, err := p.PDF(context.Background(), "https://example.org/", failingWriter{}, nil)
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("expected the writer's error, got %v", err)
+	}
+}
+
+func Test_PDFOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		po    PDFOptions
+		valid bool
+	}{
+		{"zero", PDFOptions{}, true},
+		{"named size", PDFOptions{PageSize: "letter"}, true},
+		{"custom size", PDFOptions{PageWidth: "8.5in", PageHeight: "11in"}, true},
+		{"unknown size", PDFOptions{PageSize: "B5"}, false},
+		{"size and dimensions", PDFOptions{PageSize: "A4", PageWidth: "8in"}, false},
+		{"bad margin", PDFOptions{MarginLeft: "1 inch"}, false},
+		{"scale", PDFOptions{Scale: 3}, false},
+		{"template without flag", PDFOptions{HeaderTemplate: "<b>hi</b>"}, false},
+		{"template with line break", PDFOptions{DisplayHeaderFooter: true, HeaderTemplate: "a\nb"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.po.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: unexpected Validate result %v", test.name, err)
+		}
+	}
+}
@@ -4,31 +4,120 @@ package prerendercloud
 
 import (
 	"compress/gzip"
-	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
-	e "github.com/jqatampa/gadget-arm/errors"
 	"github.com/valyala/fasthttp"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/urlfetch"
 )
 
+// DefaultTimeout is the render timeout used by NewOptions.
+const DefaultTimeout = 30 * time.Second
+
 // Options provides you with the ability to specify a custom Prerender.cloud URL
 // as well as a Prerender.cloud Token to include as an X-Prerender-Token header
 // to the upstream server.
 type Options struct {
-	PrerenderURL   *url.URL
-	Token          string
+	PrerenderURL *url.URL
+	Token        string
+	BotsOnly     bool
+
+	// Bots recognizes crawlers when BotsOnly is set; see AddBots and
+	// RemoveBots. When nil, the CrawlerUserAgents in effect when NewPrerender
+	// is called are used.
+	Bots *BotMatcher
+
+	// HTTPClient is used for upstream requests. When nil, NewPrerender creates
+	// a client with its own transport, shared by every request and keeping
+	// enough idle connections to the service for bursts of crawlers.
+	HTTPClient *http.Client
+
+	// ClientFactory, when set, returns the client to use for each upstream
+	// request made on behalf of an incoming net/http request r, instead of
+	// HTTPClient. It is only used while r is being served: background
+	// refreshes, renders still shared with other requests after r has gone,
+	// the fasthttp handler and the API clients use HTTPClient.
+	ClientFactory func(r *http.Request) *http.Client
+
+	// FastHTTPClient is used by PreRenderHandlerFastHttp to call the service,
+	// so fasthttp requests never go through net/http. When nil, NewPrerender
+	// creates one with NewFastHTTPClient, shared by every request. Unlike
+	// HTTPClient it ignores the HTTP_PROXY and HTTPS_PROXY environment
+	// variables, and can't abandon a request before Options.Timeout once
+	// every waiting client has gone.
+	FastHTTPClient *fasthttp.Client
+
+	// Render sets the service's rendering options, sent as request headers
+	// with every render. Check them with Validate.
+	Render *RenderOptions
+
+	// Timeout bounds each upstream render request, including retries. Zero
+	// means no timeout.
+	Timeout time.Duration
+
+	// Retry, when set, retries failed upstream requests. See
+	// DefaultRetryPolicy.
+	Retry *RetryPolicy
+
+	// CircuitBreaker, when set, stops sending requests to the service while
+	// it is failing so they fall through straight away. See
+	// NewCircuitBreaker.
+	CircuitBreaker *CircuitBreaker
+
+	// Cache, when set, stores prerendered responses so repeated requests for
+	// the same URL don't go back to the service. See NewLRUCache.
+	Cache Cache
+
+	// StaleWhileRevalidate is how long past expiry a cached response is still
+	// served while it is refreshed in the background.
+	StaleWhileRevalidate time.Duration
+
+	// StaleIfError is how long past expiry a cached response is served
+	// instead of falling through when the service fails.
+	StaleIfError time.Duration
+
+	// RefreshWorkers bounds concurrent background refreshes. Zero means
+	// DefaultRefreshWorkers.
+	RefreshWorkers int
+
+	// TrustedProxies lists the peers whose Forwarded, X-Forwarded-Proto,
+	// X-Forwarded-Host and CF-Visitor headers are trusted to describe the
+	// scheme and host the client used. Headers from other peers are ignored.
+	// Use netip.MustParsePrefix("0.0.0.0/0") and "::/0" to trust every peer.
+	TrustedProxies []netip.Prefix
+
+	// AllowedHosts, when not empty, restricts prerendering to requests for
+	// these hosts so a spoofed Host header can't make the service render
+	// someone else's site with your token. See HostAllowed. Requests for other
+	// hosts are passed through.
+	AllowedHosts []string
+
+	// CanonicalHost, when set, replaces the request's host in the URL sent to
+	// the service, so every alias of the site shares one rendering.
+	CanonicalHost string
+
+	// Routes override Render, BotsOnly, Bots and the cache lifetime for
+	// matching paths. The first matching route applies. See MatchRoute.
+	Routes []Route
+
+	// IncludePaths, when not empty, restricts prerendering to paths matching
+	// one of the patterns. ExcludePaths are never prerendered, such as
+	// Glob("/api/**") or Glob("/healthz"). See MatchPathRule.
+	IncludePaths []PathPattern
+	ExcludePaths []PathPattern
+
+	// Deprecated: set ClientFactory to a func returning
+	// urlfetch.Client(appengine.NewContext(r)) instead.
 	UsingAppEngine bool
-	BotsOnly       bool
 }
 
 // NewOptions generates a default Options struct pointing to the Prerender.cloud
@@ -45,6 +134,7 @@ func NewOptions() *Options {
 	return &Options{
 		PrerenderURL:   url,
 		Token:          os.Getenv("PRERENDER_TOKEN"),
+		Timeout:        DefaultTimeout,
 		UsingAppEngine: false,
 		BotsOnly:       false,
 	}
@@ -54,113 +144,94 @@ func NewOptions() *Options {
 // upstream server.
 type Prerender struct {
 	Options *Options
+
+	client      *http.Client
+	fastClient  *fasthttp.Client
+	defaultBots *BotMatcher
+
+	refreshMu    sync.Mutex
+	refreshing   map[string]bool
+	refreshSlots chan struct{}
+
+	callsMu sync.Mutex
+	calls   map[string]*call
 }
 
 // NewPrerender generates a new Prerender instance.
 func (o *Options) NewPrerender() *Prerender {
-	return &Prerender{Options: o}
-}
+	if o.UsingAppEngine && o.ClientFactory == nil {
+		o.ClientFactory = appEngineClient
+	}
 
-// ServeHTTP allows Prerender to act as a Negroni middleware.
-func (p *Prerender) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	if p.ShouldPrerender(r) {
-		p.PreRenderHandler(rw, r, next)
-	} else if next != nil {
-		next(rw, r)
+	return &Prerender{
+		Options:     o,
+		client:      &http.Client{Transport: newTransport(o.Timeout)},
+		fastClient:  NewFastHTTPClient(),
+		defaultBots: NewBotMatcher(CrawlerUserAgents...),
 	}
 }
 
-func (p *Prerender) ShouldPrerenderFastHttp(ctx *fasthttp.RequestCtx) bool {
-	userAgent := strings.ToLower(string(ctx.UserAgent()))
-	method := strings.ToLower(string(ctx.Method()))
-
-	if userAgent == "" || userAgent == "prerendercloud" {
-		return false
+// newTransport returns the transport for the client NewPrerender creates.
+// Every render goes to the same host, so it keeps far more idle connections
+// per host than http.DefaultTransport. A DefaultTransport replaced by the
+// application, for instrumentation for example, is used as is.
+func newTransport(timeout time.Duration) http.RoundTripper {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.DefaultTransport
 	}
 
-	if string(ctx.Request.Header.Peek("X-Prerendered")) != "" {
-		return false
-	}
+	transport := base.Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.MaxIdleConns = 256
+	transport.MaxIdleConnsPerHost = 64
+	transport.IdleConnTimeout = 90 * time.Second
+	// renders legitimately take a while, so only Options.Timeout bounds them
+	transport.ResponseHeaderTimeout = timeout
+	return transport
+}
 
-	if method != "get" && method != "head" {
-		return false
+// httpClient returns the client for an upstream request on behalf of or, which
+// is nil when serving through fasthttp or calling the API.
+func (p *Prerender) httpClient(or *http.Request) *http.Client {
+	if p.Options.ClientFactory != nil && or != nil {
+		return p.Options.ClientFactory(or)
 	}
-
-	if !prerenderableExtension(string(ctx.Path())) {
-		return false
+	if p.Options.HTTPClient != nil {
+		return p.Options.HTTPClient
 	}
+	return p.client
+}
 
-	if p.Options.BotsOnly {
-		isRequestingPrerenderedPage := false
-		bufferAgent := string(ctx.Request.Header.Peek("X-Bufferbot"))
-
-		// Buffer Agent or requesting an escaped fragment, request prerender
-		if bufferAgent != "" || strings.Contains(string(ctx.QueryArgs().QueryString()), "_escaped_fragment_") {
-			isRequestingPrerenderedPage = true
-		}
-
-		// Crawler, request prerender
-		for _, crawlerAgent := range CrawlerUserAgents {
-			if strings.Contains(crawlerAgent, strings.ToLower(userAgent)) {
-				isRequestingPrerenderedPage = true
-				break
-			}
-		}
+// fastHTTPClient returns the client PreRenderHandlerFastHttp calls the
+// service with.
+func (p *Prerender) fastHTTPClient() *fasthttp.Client {
+	if p.Options.FastHTTPClient != nil {
+		return p.Options.FastHTTPClient
+	}
+	return p.fastClient
+}
 
-		return isRequestingPrerenderedPage
-	} else {
-		return true
+// ServeHTTP allows Prerender to act as a Negroni middleware.
+func (p *Prerender) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if p.ShouldPrerender(r) {
+		p.PreRenderHandler(rw, r, next)
+	} else if next != nil {
+		next(rw, r)
 	}
 }
 
+// ShouldPrerenderFastHttp analyzes the request to determine whether it should
+// be routed to a Prerender.cloud upstream server.
+func (p *Prerender) ShouldPrerenderFastHttp(ctx *fasthttp.RequestCtx) bool {
+	return p.shouldPrerender(fasthttpRequest{ctx})
+}
+
 // ShouldPrerender analyzes the request to determine whether it should be routed
 // to a Prerender.cloud upstream server.
 func (p *Prerender) ShouldPrerender(or *http.Request) bool {
-	userAgent := strings.ToLower(or.Header.Get("User-Agent"))
-	method := strings.ToLower(or.Method)
-
-	// No user agent, don't prerender
-	if userAgent == "" || userAgent == "prerendercloud" {
-		return false
-	}
-
-	if or.Header.Get("X-Prerendered") != "" {
-		return false
-	}
-
-	if method != "get" && method != "head" {
-		return false
-	}
-
-	if !prerenderableExtension(or.URL.EscapedPath()) {
-		return false
-	}
-
-	if p.Options.BotsOnly {
-		bufferAgent := or.Header.Get("X-Bufferbot")
-		isRequestingPrerenderedPage := false
-
-		// var isEscapedFragment bool
-		_, isEscapedFragment := or.URL.Query()["_escaped_fragment_"]
-
-		// Buffer Agent or requesting an escaped fragment, request prerender
-		if bufferAgent != "" || isEscapedFragment {
-			isRequestingPrerenderedPage = true
-		}
-
-		// Cralwer, request prerender
-		for _, crawlerAgent := range CrawlerUserAgents {
-			if strings.Contains(crawlerAgent, strings.ToLower(userAgent)) {
-				isRequestingPrerenderedPage = true
-				break
-			}
-		}
-
-		return isRequestingPrerenderedPage
-	} else {
-		return true
-	}
-
+	return p.shouldPrerender(httpRequest{or})
 }
 
 func prerenderableExtension(fullpath string) bool {
@@ -188,25 +259,6 @@ func prerenderableExtension(fullpath string) bool {
 	return false
 }
 
-func (p *Prerender) buildURLforFastHttp(ctx *fasthttp.RequestCtx) string {
-	return buildApiUrl(
-		p.Options.PrerenderURL.String(),
-		string(ctx.URI().Scheme()),
-		string(ctx.Host()),
-		string(ctx.Path()),
-		string(ctx.URI().QueryString()),
-	)
-}
-
-func (p *Prerender) buildURLforHttp(or *http.Request) string {
-	return buildApiUrl(p.Options.PrerenderURL.String(),
-		or.URL.Scheme,
-		or.Host,
-		or.URL.Path,
-		or.URL.RawQuery,
-	)
-}
-
 func buildApiUrl(prerenderServiceUrl, protocol, host, path, rawQuery string) string {
 	if !strings.HasSuffix(prerenderServiceUrl, "/") {
 		prerenderServiceUrl += "/"
@@ -229,106 +281,155 @@ func buildApiUrl(prerenderServiceUrl, protocol, host, path, rawQuery string) str
 	return apiUrl
 }
 
-func (p *Prerender) PreRenderHandlerFastHttp(ctx *fasthttp.RequestCtx) error {
+// fetch performs an upstream request and reads the whole response, so nothing
+// reaches the downstream client until the upstream request is known to have
+// succeeded. A 5xx response is returned along with an UpstreamError so callers
+// without a fallback can still pass it through.
+func (p *Prerender) fetch(client httpDoer, req *http.Request) (*CachedResponse, error) {
+	res, err := p.send(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", p.buildURLforFastHttp(ctx), nil)
-	e.Check(err)
+	body, err := readBody(res.Header.Get("Content-Encoding"), res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	return newCachedResponse(res.StatusCode, res.Header, body)
+}
 
-	if p.Options.Token != "" {
-		req.Header.Set("X-Prerender-Token", p.Options.Token)
+// readBody reads a response body from the service, which is gzipped when
+// contentEncoding says so.
+func readBody(contentEncoding string, r io.Reader) ([]byte, error) {
+	if strings.Contains(contentEncoding, "gzip") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, transportError(err, DecodeError)
+		}
+		defer gz.Close()
+		r = gz
 	}
 
-	req.Header.Set("X-Original-User-Agent", string(ctx.Request.Header.Peek("User-Agent")))
-	req.Header.Set("User-Agent", "prerender-cloud-golang-middleware")
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, transportError(err, DecodeError)
+	}
+	return body, nil
+}
 
-	res, err := client.Do(req)
-	e.Check(err)
-	defer res.Body.Close()
+// newCachedResponse wraps a decoded upstream response, returning an
+// UpstreamError along with it for 5xx responses.
+func newCachedResponse(statusCode int, header http.Header, body []byte) (*CachedResponse, error) {
+	resp := &CachedResponse{StatusCode: statusCode, Header: header, Body: body}
 
-	if res.StatusCode >= 500 && res.StatusCode <= 511 {
-		fmt.Println("prerender.cloud server error", res.StatusCode)
-		return errors.New("prerendercloud server error")
+	if statusCode >= 500 && statusCode <= 511 {
+		return resp, &Error{Kind: UpstreamError, StatusCode: statusCode}
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	e.Check(err)
+	return resp, nil
+}
+
+// PreRenderHandlerFastHttp proxies the request to the configured
+// Prerender.cloud URL and writes the prerendered response to ctx. When the
+// upstream request fails it returns an *Error and leaves ctx untouched. The
+// service is called through Options.FastHTTPClient.
+func (p *Prerender) PreRenderHandlerFastHttp(ctx *fasthttp.RequestCtx) error {
+	// RequestCtx is a context.Context that is cancelled on server shutdown;
+	// fetch derives the render deadline from it.
+	route := p.route(fasthttpRequest{ctx})
+
+	u := p.newFastHttpUpstream(p.fastHTTPClient(), ctx, route)
+	defer u.release()
+
+	res, err := p.render(u, route.cacheTTL())
+	if err != nil {
+		return err
+	}
 
 	fasthttp.CompressHandler(func(ctx *fasthttp.RequestCtx) {
 		ctx.SetStatusCode(res.StatusCode)
-		if len(res.Header["Content-Type"]) > 0 {
-			ctx.SetContentType(res.Header["Content-Type"][0])
+		if contentType := res.Header.Get("Content-Type"); contentType != "" {
+			ctx.SetContentType(contentType)
 		}
 
-		ctx.SetBody(body)
+		ctx.SetBody(res.Body)
 	})(ctx)
 
 	return nil
 }
 
-// PreRenderHandler is a net/http compatible handler that proxies a request to
-// the configured Prerender.cloud URL.  All upstream requests are made with an
-// Accept-Encoding=gzip header.  Responses are provided either uncompressed or
-// gzip compressed based on the downstream requests Accept-Encoding header
-func (p *Prerender) PreRenderHandler(rw http.ResponseWriter, or *http.Request, next http.HandlerFunc) {
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", p.buildURLforHttp(or), nil)
-	e.Check(err)
+func (p *Prerender) renderHttp(or *http.Request) (*CachedResponse, error) {
+	v := httpRequest{or}
+	route := p.route(v)
 
-	if p.Options.Token != "" {
-		req.Header.Set("X-Prerender-Token", p.Options.Token)
+	req, err := p.newUpstreamRequest(or.Context(), v, route)
+	if err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("X-Original-User-Agent", or.Header.Get("User-Agent"))
-	req.Header.Set("User-Agent", "prerender-cloud-golang-middleware")
-	req.Header.Set("Content-Type", or.Header.Get("Content-Type"))
-	req.Header.Set("Accept-Encoding", "gzip")
+	return p.render(&httpUpstream{p: p, or: or, req: req}, route.cacheTTL())
+}
 
-	if p.Options.UsingAppEngine {
-		ctx := appengine.NewContext(or)
-		client = urlfetch.Client(ctx)
+// PreRender proxies the request to the configured Prerender.cloud URL and
+// writes the prerendered response to rw. When the upstream request fails it
+// returns an *Error and leaves rw untouched, so the caller can serve the
+// request itself.
+func (p *Prerender) PreRender(rw http.ResponseWriter, or *http.Request) error {
+	res, err := p.renderHttp(or)
+	if err != nil {
+		return err
 	}
 
-	res, err := client.Do(req)
-	e.Check(err)
-	defer res.Body.Close()
+	writeResponse(rw, or, res)
+	return nil
+}
 
-	if res.StatusCode >= 500 && res.StatusCode <= 511 && next != nil {
-		fmt.Println("prerender.cloud server error", res.StatusCode)
+// PreRenderHandler is a net/http compatible handler that proxies a request to
+// the configured Prerender.cloud URL.  All upstream requests are made with an
+// Accept-Encoding=gzip header.  Responses are provided either uncompressed or
+// gzip compressed based on the downstream requests Accept-Encoding header.
+// If the upstream request fails for any reason the request falls through to
+// next; without a next handler, upstream 5xx responses are passed through and
+// other failures produce a 502 Bad Gateway. When the downstream client goes
+// away the upstream request is cancelled and nothing is written.
+func (p *Prerender) PreRenderHandler(rw http.ResponseWriter, or *http.Request, next http.HandlerFunc) {
+	res, err := p.renderHttp(or)
+	if IsErrorKind(err, CanceledError) {
+		return
+	}
+
+	if err != nil {
 		if next != nil {
 			next(rw, or)
 			return
 		}
+
+		if res == nil {
+			http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
 	}
 
-	rw.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+	writeResponse(rw, or, res)
+}
 
-	//Figure out whether the client accepts gzip responses
-	doGzip := strings.Contains(or.Header.Get("Accept-Encoding"), "gzip")
-	isGzip := strings.Contains(res.Header.Get("Content-Encoding"), "gzip")
+func writeResponse(rw http.ResponseWriter, or *http.Request, res *CachedResponse) {
+	rw.Header().Set("Content-Type", res.Header.Get("Content-Type"))
 
-	if doGzip && !isGzip {
-		// gzip raw response
+	// Figure out whether the client accepts gzip responses
+	if strings.Contains(or.Header.Get("Accept-Encoding"), "gzip") {
 		rw.Header().Set("Content-Encoding", "gzip")
 		rw.WriteHeader(res.StatusCode)
 		gz := gzip.NewWriter(rw)
 		defer gz.Close()
-		io.Copy(gz, res.Body)
-		gz.Flush()
-
-	} else if !doGzip && isGzip {
-		rw.WriteHeader(res.StatusCode)
-		// gunzip response
-		gz, err := gzip.NewReader(res.Body)
-		e.Check(err)
-		defer gz.Close()
-		io.Copy(rw, gz)
-	} else {
-		// Pass through, gzip/gzip or raw/raw
-		rw.Header().Set("Content-Encoding", res.Header.Get("Content-Encoding"))
-		rw.WriteHeader(res.StatusCode)
-		io.Copy(rw, res.Body)
+		gz.Write(res.Body)
+		return
 	}
 
+	rw.WriteHeader(res.StatusCode)
+	rw.Write(res.Body)
 }
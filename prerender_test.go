@@ -1,6 +1,13 @@
 package prerendercloud
 
-import "testing"
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
 
 func Test_prerenderableExtension(t *testing.T) {
 	if prerenderableExtension("") != true {
@@ -67,3 +74,69 @@ func Test_buildUrlWithQueryAndPath(t *testing.T) {
 		t.Error("malformed API URL")
 	}
 }
+
+// Test_ClientFactoryWithoutRequest checks that paths without an incoming
+// net/http request don't call ClientFactory, which for UsingAppEngine panics
+// on a nil request.
+func Test_ClientFactoryWithoutRequest(t *testing.T) {
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"title": "A post"}`))
+	})
+	p.Options.ClientFactory = appEngineClient
+
+	if _, err := p.Metadata(context.Background(), "https://example.org/post"); err != nil {
+		t.Errorf("Error, Metadata should use HTTPClient, got %v", err)
+	}
+
+	fp := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("prerendered response")
+	})
+	fp.Options.ClientFactory = appEngineClient
+
+	if ctx, err := serveFastHttp(fp, "http://www.example.com/", "Twitterbot/1.0"); err != nil || string(ctx.Response.Body()) != "prerendered response" {
+		t.Errorf("Error, PreRenderHandlerFastHttp should use FastHTTPClient, got %q %v", ctx.Response.Body(), err)
+	}
+}
+
+func Test_ClientFactoryWithRequest(t *testing.T) {
+	fp := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("prerendered response")
+	})
+
+	var called *http.Request
+	fp.Options.ClientFactory = func(r *http.Request) *http.Client {
+		called = r
+		return fp.Options.HTTPClient
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "Twitterbot/1.0")
+	fp.ServeHTTP(res, req, nil)
+
+	if called != req || res.Body.String() != "prerendered response" {
+		t.Errorf("Error, ClientFactory should be called with the incoming request, got %v %q", called, res.Body.String())
+	}
+}
+
+func Test_NewPrerenderPoolsConnections(t *testing.T) {
+	p := NewOptions().NewPrerender()
+
+	transport, ok := p.httpClient(nil).Transport.(*http.Transport)
+	if !ok || transport == http.DefaultTransport {
+		t.Fatalf("Error, expected a dedicated *http.Transport, got %T", p.httpClient(nil).Transport)
+	}
+	if transport.MaxIdleConnsPerHost <= http.DefaultMaxIdleConnsPerHost {
+		t.Errorf("Error, expected more than %d idle connections per host, got %d", http.DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	}
+	if transport.ResponseHeaderTimeout != DefaultTimeout || transport.TLSHandshakeTimeout == 0 {
+		t.Errorf("Error, expected timeouts to be set, got %v %v", transport.ResponseHeaderTimeout, transport.TLSHandshakeTimeout)
+	}
+
+	if client := p.fastHTTPClient(); client == nil || !client.DisablePathNormalizing {
+		t.Errorf("Error, expected a pooled fasthttp.Client by default, got %+v", client)
+	}
+	if p.fastHTTPClient() != p.fastHTTPClient() {
+		t.Error("Error, expected the fasthttp.Client to be shared between requests")
+	}
+}
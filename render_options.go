@@ -0,0 +1,189 @@
+package prerendercloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxDeviceSize is the largest DeviceWidth or DeviceHeight accepted by
+// RenderOptions.Validate.
+const MaxDeviceSize = 10000
+
+// RenderOptions controls how the service renders pages. Each field maps to a
+// request header understood by headless-render-api.com; zero values leave the
+// service defaults in place.
+type RenderOptions struct {
+	// WaitExtraLong gives pages more time to finish loading before the
+	// snapshot is taken.
+	WaitExtraLong bool
+
+	// DisableAjaxPreload stops the service from inlining the page's XHR
+	// responses into the rendered HTML.
+	DisableAjaxPreload bool
+
+	// DisableAjaxBypass stops the service from answering the page's XHR
+	// requests from its own cache.
+	DisableAjaxBypass bool
+
+	// RemoveScriptTags strips <script> tags from the rendered HTML.
+	RemoveScriptTags bool
+
+	// RemoveTrailingSlash renders /path/ as /path.
+	RemoveTrailingSlash bool
+
+	// FollowRedirects makes the service follow redirects issued by the
+	// origin instead of passing them through.
+	FollowRedirects bool
+
+	// DeviceWidth and DeviceHeight set the viewport size in pixels.
+	DeviceWidth  int
+	DeviceHeight int
+
+	// OriginHeaderWhitelist names incoming request headers, such as
+	// Authorization or Cookie, that are forwarded to the service and on to
+	// the origin while rendering. Their values are part of the key for
+	// Options.Cache and for sharing in-flight renders, so each user gets
+	// their own render.
+	OriginHeaderWhitelist []string
+
+	// Recache makes the service render the page again instead of serving
+	// its own cached copy.
+	Recache bool
+}
+
+// headers that can't be whitelisted because the middleware sets them itself
+var reservedHeaders = map[string]bool{
+	"Accept-Encoding":         true,
+	"Content-Length":          true,
+	"Host":                    true,
+	"Origin-Header-Whitelist": true,
+	"User-Agent":              true,
+	"X-Original-User-Agent":   true,
+	"X-Prerender-Token":       true,
+}
+
+// Validate reports whether the options can be sent to the service.
+func (ro *RenderOptions) Validate() error {
+	if ro.DeviceWidth < 0 || ro.DeviceWidth > MaxDeviceSize {
+		return fmt.Errorf("prerendercloud: DeviceWidth %d out of range 0-%d", ro.DeviceWidth, MaxDeviceSize)
+	}
+
+	if ro.DeviceHeight < 0 || ro.DeviceHeight > MaxDeviceSize {
+		return fmt.Errorf("prerendercloud: DeviceHeight %d out of range 0-%d", ro.DeviceHeight, MaxDeviceSize)
+	}
+
+	for _, name := range ro.OriginHeaderWhitelist {
+		if !validHeaderName(name) {
+			return fmt.Errorf("prerendercloud: invalid OriginHeaderWhitelist header name %q", name)
+		}
+
+		if canonical := http.CanonicalHeaderKey(name); reservedHeader(canonical) {
+			return fmt.Errorf("prerendercloud: OriginHeaderWhitelist can't include %s", canonical)
+		}
+	}
+
+	return nil
+}
+
+func reservedHeader(canonical string) bool {
+	return reservedHeaders[canonical] || strings.HasPrefix(canonical, "Prerender-")
+}
+
+// setHeaders adds the headers for ro to an upstream request made on behalf of
+// v, including the whitelisted headers copied from v. Options that Validate
+// would reject are left out, since nothing forces it to be called.
+func (ro *RenderOptions) setHeaders(v requestView, header headerSetter) {
+	flags := []struct {
+		set    bool
+		header string
+	}{
+		{ro.WaitExtraLong, "Prerender-Wait-Extra-Long"},
+		{ro.DisableAjaxPreload, "Prerender-Disable-Ajax-Preload"},
+		{ro.DisableAjaxBypass, "Prerender-Disable-Ajax-Bypass"},
+		{ro.RemoveScriptTags, "Prerender-Remove-Script-Tags"},
+		{ro.RemoveTrailingSlash, "Prerender-Remove-Trailing-Slash"},
+		{ro.FollowRedirects, "Prerender-Follow-Redirects"},
+		{ro.Recache, "Prerender-Recache"},
+	}
+
+	for _, flag := range flags {
+		if flag.set {
+			header.Set(flag.header, "true")
+		}
+	}
+
+	if ro.DeviceWidth > 0 && ro.DeviceWidth <= MaxDeviceSize {
+		header.Set("Prerender-Device-Width", strconv.Itoa(ro.DeviceWidth))
+	}
+
+	if ro.DeviceHeight > 0 && ro.DeviceHeight <= MaxDeviceSize {
+		header.Set("Prerender-Device-Height", strconv.Itoa(ro.DeviceHeight))
+	}
+
+	var names []string
+	for _, name := range ro.OriginHeaderWhitelist {
+		canonical := http.CanonicalHeaderKey(name)
+		if !validHeaderName(name) || reservedHeader(canonical) {
+			continue
+		}
+
+		names = append(names, canonical)
+		if value := v.header(name); value != "" {
+			header.Set(canonical, value)
+		}
+	}
+	if len(names) > 0 {
+		header.Set("Origin-Header-Whitelist", strings.Join(names, " "))
+	}
+}
+
+// Validate reports whether the options can be used to serve requests.
+func (o *Options) Validate() error {
+	if o.PrerenderURL == nil {
+		return errors.New("prerendercloud: PrerenderURL is required")
+	}
+
+	if o.Render != nil {
+		if err := o.Render.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if o.FastHTTPClient != nil && !o.FastHTTPClient.DisablePathNormalizing {
+		return errors.New("prerendercloud: FastHTTPClient must set DisablePathNormalizing; see NewFastHTTPClient")
+	}
+
+	for _, route := range o.Routes {
+		name := route.Path.String()
+		if route.Pattern != "" {
+			name = route.Pattern
+		}
+		if name == "" {
+			return errors.New("prerendercloud: route without a Path or Pattern")
+		}
+		if route.Render != nil {
+			if err := route.Render.Validate(); err != nil {
+				return fmt.Errorf("%w (route %s)", err, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		isAlnum := ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+		if !isAlnum && !strings.ContainsRune("!#$%&'*+-.^_`|~", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package prerendercloud
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func Test_RenderOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		ro    RenderOptions
+		valid bool
+	}{
+		{"zero", RenderOptions{}, true},
+		{"device size", RenderOptions{DeviceWidth: 1280, DeviceHeight: 800}, true},
+		{"negative width", RenderOptions{DeviceWidth: -1}, false},
+		{"huge height", RenderOptions{DeviceHeight: MaxDeviceSize + 1}, false},
+		{"whitelist", RenderOptions{OriginHeaderWhitelist: []string{"Authorization", "x-api-key"}}, true},
+		{"invalid header name", RenderOptions{OriginHeaderWhitelist: []string{"Bad Header"}}, false},
+		{"token header", RenderOptions{OriginHeaderWhitelist: []string{"x-prerender-token"}}, false},
+		{"prerender header", RenderOptions{OriginHeaderWhitelist: []string{"Prerender-Recache"}}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.ro.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: unexpected Validate result %v", test.name, err)
+		}
+	}
+}
+
+func Test_OptionsValidate(t *testing.T) {
+	options := NewOptions()
+	if err := options.Validate(); err != nil {
+		t.Errorf("default options should be valid: %v", err)
+	}
+
+	options.Render = &RenderOptions{DeviceWidth: -1}
+	if options.Validate() == nil {
+		t.Error("invalid render options should fail validation")
+	}
+}
+
+// Test_upstreamRequestGolden checks the complete outgoing request for a fully
+// populated RenderOptions from both adapters.
+func Test_upstreamRequestGolden(t *testing.T) {
+	options := NewOptions()
+	options.PrerenderURL, _ = url.Parse("https://service.headless-render-api.com/")
+	options.Token = "secret"
+	options.Render = &RenderOptions{
+		WaitExtraLong:         true,
+		DisableAjaxPreload:    true,
+		DisableAjaxBypass:     true,
+		RemoveScriptTags:      true,
+		RemoveTrailingSlash:   true,
+		FollowRedirects:       true,
+		DeviceWidth:           1280,
+		DeviceHeight:          800,
+		OriginHeaderWhitelist: []string{"authorization", "X-Missing"},
+		Recache:               true,
+	}
+	p := options.NewPrerender()
+
+	c := conformanceCase{
+		method: "GET",
+		url:    "http://www.example.com/products/1?color=red",
+		headers: map[string]string{
+			"User-Agent":    "Twitterbot/1.0",
+			"Authorization": "Bearer abc",
+			"Cookie":        "session=1",
+		},
+	}
+
+	golden := http.Header{
+		"X-Original-User-Agent":           {"Twitterbot/1.0"},
+		"Accept-Encoding":                 {"gzip"},
+		"Prerender-Wait-Extra-Long":       {"true"},
+		"Prerender-Disable-Ajax-Preload":  {"true"},
+		"Prerender-Disable-Ajax-Bypass":   {"true"},
+		"Prerender-Remove-Script-Tags":    {"true"},
+		"Prerender-Remove-Trailing-Slash": {"true"},
+		"Prerender-Follow-Redirects":      {"true"},
+		"Prerender-Recache":               {"true"},
+		"Prerender-Device-Width":          {"1280"},
+		"Prerender-Device-Height":         {"800"},
+		"Authorization":                   {"Bearer abc"},
+		"Origin-Header-Whitelist":         {"Authorization X-Missing"},
+	}
+
+	for _, v := range []requestView{c.httpRequest(t), c.fasthttpRequest(t)} {
+		req, err := p.newUpstreamRequest(context.Background(), v, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if req.URL.String() != "https://service.headless-render-api.com/http://www.example.com/products/1?color=red" {
+			t.Errorf("%T: unexpected URL %s", v, req.URL)
+		}
+
+		if !reflect.DeepEqual(req.Header, golden) {
+			t.Errorf("%T: unexpected headers\n got: %v\nwant: %v", v, req.Header, golden)
+		}
+	}
+}
+
+func Test_setHeadersSkipsInvalidOptions(t *testing.T) {
+	options := NewOptions()
+	options.Render = &RenderOptions{
+		DeviceWidth:           MaxDeviceSize + 1,
+		DeviceHeight:          -1,
+		OriginHeaderWhitelist: []string{"Host", "bad name", "Prerender-Recache", "X-Custom"},
+	}
+	p := options.NewPrerender()
+
+	c := conformanceCase{
+		method:  "GET",
+		url:     "http://www.example.com/",
+		headers: map[string]string{"User-Agent": "Twitterbot/1.0", "Host": "attacker.example", "X-Custom": "1"},
+	}
+
+	hv := c.httpRequest(t)
+	req, err := p.newUpstreamRequest(context.Background(), hv, p.route(hv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Prerender-Device-Width") != "" || req.Header.Get("Prerender-Device-Height") != "" {
+		t.Errorf("Error, out of range device sizes should not be sent, got %v", req.Header)
+	}
+	if whitelist := req.Header.Get("Origin-Header-Whitelist"); whitelist != "X-Custom" {
+		t.Errorf("Error, only valid, unreserved headers should be whitelisted, got %q", whitelist)
+	}
+
+	fv := c.fasthttpRequest(t).(fasthttpRequest)
+	u := p.newFastHttpUpstream(p.fastHTTPClient(), fv.ctx, p.route(fv))
+	defer u.release()
+	if host := u.req.Header.Host(); len(host) > 0 {
+		t.Errorf("Error, a whitelisted Host should not replace the service's, got %q", host)
+	}
+}
@@ -0,0 +1,164 @@
+package prerendercloud
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// requestView is a read-only view of an incoming request. Every prerendering
+// decision, the upstream URL and the forwarded headers are derived from it, so
+// net/http and fasthttp requests are handled by the same code.
+type requestView interface {
+	method() string
+	// path is the decoded request path.
+	path() string
+	rawQuery() string
+	header(name string) string
+	host() string
+	// scheme is "" when the request doesn't say.
+	scheme() string
+	isTLS() bool
+	remoteAddr() string
+	// routePattern is the pattern the request was routed by, or "".
+	routePattern() string
+}
+
+type httpRequest struct {
+	r *http.Request
+}
+
+func (v httpRequest) method() string            { return v.r.Method }
+func (v httpRequest) path() string              { return v.r.URL.Path }
+func (v httpRequest) rawQuery() string          { return v.r.URL.RawQuery }
+func (v httpRequest) header(name string) string { return v.r.Header.Get(name) }
+func (v httpRequest) host() string              { return v.r.Host }
+func (v httpRequest) scheme() string            { return v.r.URL.Scheme }
+func (v httpRequest) isTLS() bool               { return v.r.TLS != nil }
+func (v httpRequest) remoteAddr() string        { return v.r.RemoteAddr }
+func (v httpRequest) routePattern() string      { return RoutePattern(v.r.Context()) }
+
+type fasthttpRequest struct {
+	ctx *fasthttp.RequestCtx
+}
+
+func (v fasthttpRequest) method() string   { return string(v.ctx.Method()) }
+func (v fasthttpRequest) path() string     { return string(v.ctx.Path()) }
+func (v fasthttpRequest) rawQuery() string { return string(v.ctx.URI().QueryString()) }
+func (v fasthttpRequest) header(name string) string {
+	return string(v.ctx.Request.Header.Peek(name))
+}
+func (v fasthttpRequest) host() string       { return string(v.ctx.Host()) }
+func (v fasthttpRequest) scheme() string     { return string(v.ctx.URI().Scheme()) }
+func (v fasthttpRequest) isTLS() bool        { return v.ctx.IsTLS() }
+func (v fasthttpRequest) remoteAddr() string { return v.ctx.RemoteAddr().String() }
+func (v fasthttpRequest) routePattern() string {
+	return RoutePattern(v.ctx)
+}
+
+// shouldPrerender implements ShouldPrerender and ShouldPrerenderFastHttp.
+func (p *Prerender) shouldPrerender(v requestView) bool {
+	userAgent := v.header("User-Agent")
+	method := strings.ToLower(v.method())
+
+	// No user agent, don't prerender
+	if userAgent == "" || strings.EqualFold(userAgent, "prerendercloud") {
+		return false
+	}
+
+	if v.header("X-Prerendered") != "" {
+		return false
+	}
+
+	if method != "get" && method != "head" {
+		return false
+	}
+
+	if !prerenderableExtension(v.path()) {
+		return false
+	}
+
+	if _, allowed := p.Options.MatchPathRule(v.path()); !allowed {
+		return false
+	}
+
+	if _, host := p.origin(v); !p.Options.HostAllowed(host) {
+		return false
+	}
+
+	route := p.route(v)
+	if !route.botsOnly(p.Options) {
+		return true
+	}
+
+	// Buffer Agent or requesting an escaped fragment, request prerender
+	if v.header("X-Bufferbot") != "" {
+		return true
+	}
+
+	if query, err := url.ParseQuery(v.rawQuery()); err == nil {
+		if _, isEscapedFragment := query["_escaped_fragment_"]; isEscapedFragment {
+			return true
+		}
+	}
+
+	// Crawler, request prerender; Match lowercases for substrings itself and
+	// regexps see the user agent as sent
+	if route != nil && route.Bots != nil {
+		return route.Bots.Match(userAgent)
+	}
+	return p.bots().Match(userAgent)
+}
+
+func (p *Prerender) buildURL(v requestView) string {
+	scheme, host := p.origin(v)
+	if p.Options.CanonicalHost != "" {
+		host = p.Options.CanonicalHost
+	}
+
+	return buildApiUrl(
+		p.Options.PrerenderURL.String(),
+		scheme,
+		host,
+		(&url.URL{Path: v.path()}).EscapedPath(),
+		v.rawQuery(),
+	)
+}
+
+// headerSetter is the part of http.Header and fasthttp.RequestHeader needed to
+// build upstream requests.
+type headerSetter interface {
+	Set(key, value string)
+}
+
+// setUpstreamHeaders sets the headers of a render request made on behalf of
+// v, which matched route (nil when no route matched): those copied from v,
+// and those for the render options. The response may be gzipped; readBody
+// decodes it.
+func (p *Prerender) setUpstreamHeaders(v requestView, route *Route, header headerSetter) {
+	header.Set("X-Original-User-Agent", v.header("User-Agent"))
+	header.Set("Accept-Encoding", "gzip")
+
+	if contentType := v.header("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	if ro := route.renderOptions(p.Options); ro != nil {
+		ro.setHeaders(v, header)
+	}
+}
+
+// newUpstreamRequest builds the request to the service on behalf of v, which
+// matched route (nil when no route matched).
+func (p *Prerender) newUpstreamRequest(ctx context.Context, v requestView, route *Route) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.buildURL(v), nil)
+	if err != nil {
+		return nil, &Error{Kind: RequestError, Err: err}
+	}
+
+	p.setUpstreamHeaders(v, route, req.Header)
+	return req, nil
+}
@@ -0,0 +1,148 @@
+package prerendercloud
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+type conformanceCase struct {
+	name    string
+	method  string
+	url     string
+	headers map[string]string
+}
+
+var conformanceCases = []conformanceCase{
+	{"no user agent", "GET", "http://www.example.com/", nil},
+	{"browser", "GET", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"prerendercloud", "GET", "http://www.example.com/", map[string]string{"User-Agent": "prerendercloud"}},
+	{"already prerendered", "GET", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0", "X-Prerendered": "true"}},
+	{"post", "POST", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"head", "HEAD", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"static asset", "GET", "http://www.example.com/assets/font.woff", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"html", "GET", "http://www.example.com/deep/path.html", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"query", "GET", "http://www.example.com/search?q=shoes&page=2", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"escaped path", "GET", "http://www.example.com/a%20b/c", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"excluded", "GET", "http://www.example.com/api/users", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"escaped fragment", "GET", "http://www.example.com/?_escaped_fragment_=", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"escaped fragment substring", "GET", "http://www.example.com/?not_escaped_fragment_=1", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"bufferbot", "GET", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0", "X-Bufferbot": "true"}},
+	{"content type", "GET", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0", "Content-Type": "text/html"}},
+	{"x-forwarded", "GET", "http://10.0.0.2/", map[string]string{"User-Agent": "Mozilla/5.0", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"}},
+	{"forwarded", "GET", "http://10.0.0.2/", map[string]string{"User-Agent": "Mozilla/5.0", "Forwarded": `for=192.0.2.60;proto=https;host="www.example.com"`}},
+	{"other host", "GET", "http://evil.example/", map[string]string{"User-Agent": "Mozilla/5.0"}},
+	{"cf-visitor", "GET", "http://www.example.com/", map[string]string{"User-Agent": "Mozilla/5.0", "CF-Visitor": `{"scheme":"https"}`}},
+}
+
+// conformanceRemoteAddr is the peer every conformance request comes from.
+var conformanceRemoteAddr = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+func (c conformanceCase) httpRequest(t *testing.T) requestView {
+	req, err := http.NewRequest(c.method, c.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// server requests don't carry the scheme in their URL
+	req.URL.Scheme = ""
+	req.RemoteAddr = conformanceRemoteAddr.String()
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	return httpRequest{req}
+}
+
+func (c conformanceCase) fasthttpRequest(t *testing.T) requestView {
+	var req fasthttp.Request
+	req.Header.SetMethod(c.method)
+	req.SetRequestURI(c.url)
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, conformanceRemoteAddr, nil)
+	return fasthttpRequest{&ctx}
+}
+
+// Test_requestViewConformance checks that net/http and fasthttp requests lead
+// to identical decisions, upstream URLs and forwarded headers.
+func Test_requestViewConformance(t *testing.T) {
+	for _, botsOnly := range []bool{false, true} {
+		options := NewOptions()
+		options.BotsOnly = botsOnly
+		options.ExcludePaths = []PathPattern{Glob("/api/**")}
+		options.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+		options.AllowedHosts = []string{"www.example.com"}
+		options.Render = &RenderOptions{DeviceWidth: 1280, OriginHeaderWhitelist: []string{"Content-Type", "X-Bufferbot"}}
+		p := options.NewPrerender()
+
+		for _, c := range conformanceCases {
+			views := []requestView{c.httpRequest(t), c.fasthttpRequest(t)}
+
+			if a, b := p.shouldPrerender(views[0]), p.shouldPrerender(views[1]); a != b {
+				t.Errorf("%s (BotsOnly=%v): net/http decided %v, fasthttp decided %v", c.name, botsOnly, a, b)
+			}
+
+			if a, b := p.buildURL(views[0]), p.buildURL(views[1]); a != b {
+				t.Errorf("%s: net/http built %q, fasthttp built %q", c.name, a, b)
+			}
+
+			a, errA := p.newUpstreamRequest(context.Background(), views[0], p.route(views[0]))
+			b, errB := p.newUpstreamRequest(context.Background(), views[1], p.route(views[1]))
+			if errA != nil || errB != nil {
+				t.Fatalf("%s: %v %v", c.name, errA, errB)
+			}
+			if !reflect.DeepEqual(a.Header, b.Header) {
+				t.Errorf("%s: net/http forwarded %v, fasthttp forwarded %v", c.name, a.Header, b.Header)
+			}
+		}
+	}
+}
+
+func Test_shouldPrerender(t *testing.T) {
+	options := NewOptions()
+	options.ExcludePaths = []PathPattern{Glob("/api/**")}
+	p := options.NewPrerender()
+
+	expected := map[string]bool{
+		"no user agent":       false,
+		"browser":             true,
+		"prerendercloud":      false,
+		"already prerendered": false,
+		"post":                false,
+		"head":                true,
+		"static asset":        false,
+		"html":                true,
+		"excluded":            false,
+	}
+
+	for _, c := range conformanceCases {
+		want, ok := expected[c.name]
+		if !ok {
+			continue
+		}
+		if got := p.shouldPrerender(c.httpRequest(t)); got != want {
+			t.Errorf("%s: expected %v, got %v", c.name, want, got)
+		}
+	}
+
+	options.BotsOnly = true
+	for name, want := range map[string]bool{
+		"browser":                    false,
+		"escaped fragment":           true,
+		"escaped fragment substring": false,
+		"bufferbot":                  true,
+	} {
+		for _, c := range conformanceCases {
+			if c.name == name && p.shouldPrerender(c.httpRequest(t)) != want {
+				t.Errorf("%s (BotsOnly): expected %v", name, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package prerendercloud
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries upstream requests that time out, fail with a network
+// error such as a refused or reset connection, or get a retryable status
+// code. Errors a retry can't fix, like an unknown host or a certificate
+// error, aren't retried. It is used by the prerender handlers and by the API
+// clients.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+
+	// AttemptTimeout bounds each attempt until its response headers arrive,
+	// so a stalled attempt leaves time for another within Options.Timeout.
+	// Zero lets an attempt use all the time left.
+	AttemptTimeout time.Duration
+
+	// InitialBackoff is the wait before the first retry. Each later wait is
+	// Multiplier times longer, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter randomizes each wait by up to this fraction in either
+	// direction, from 0 to 1, so clients don't retry in lockstep.
+	Jitter float64
+
+	// RetryableStatusCodes lists the upstream statuses worth retrying.
+	RetryableStatusCodes []int
+
+	// Budget is the fraction of the time left before the request deadline,
+	// from 0 to 1, that retries may use, so a retry doesn't leave too little
+	// time to fall back. Zero lets retries use all of it. Requests without a
+	// deadline are only bounded by MaxAttempts.
+	Budget float64
+
+	// random returns a number in [0, 1); replaced in tests.
+	random func() float64
+}
+
+// DefaultRetryPolicy returns a policy making up to 3 attempts of at most 10s
+// each, retrying 502, 503 and 504 responses with 100ms, then 200ms waits,
+// using at most half of the time left before the deadline.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:          3,
+		AttemptTimeout:       10 * time.Second,
+		InitialBackoff:       100 * time.Millisecond,
+		MaxBackoff:           2 * time.Second,
+		Multiplier:           2,
+		Jitter:               0.2,
+		RetryableStatusCodes: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		Budget:               0.5,
+	}
+}
+
+// backoff returns the wait before retry number retry, counting from 1.
+func (rp *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if rp.MaxBackoff > 0 && wait > float64(rp.MaxBackoff) {
+		wait = float64(rp.MaxBackoff)
+	}
+
+	if rp.Jitter > 0 {
+		random := rp.random
+		if random == nil {
+			random = rand.Float64
+		}
+		wait += wait * rp.Jitter * (2*random() - 1)
+	}
+
+	return time.Duration(wait)
+}
+
+// attemptDeadline returns the deadline of an attempt starting now within
+// ctx, and whether there is one.
+func (rp *RetryPolicy) attemptDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if rp != nil && rp.AttemptTimeout > 0 {
+		if attempt := time.Now().Add(rp.AttemptTimeout); !ok || attempt.Before(deadline) {
+			return attempt, true
+		}
+	}
+	return deadline, ok
+}
+
+// retryableError reports whether an attempt failing with err is worth
+// retrying, provided the request's own context is still live: the attempt
+// timed out, or the connection failed. Errors wrapped by net/http in a
+// url.Error are looked at directly, as url.Error always passes for a
+// net.Error.
+func retryableError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.As(err, &netErr)
+}
+
+func (rp *RetryPolicy) retryableStatus(code int) bool {
+	for _, retryable := range rp.RetryableStatusCodes {
+		if code == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// do performs req, retrying according to Options.Retry. The last attempt's
+// response or error is returned when every attempt fails. Each attempt is
+// bounded by RetryPolicy.AttemptTimeout until its response body is closed.
+func (p *Prerender) do(client httpDoer, req *http.Request) (*http.Response, error) {
+	var res *http.Response
+	cancel := context.CancelFunc(func() {})
+	err := p.retry(req.Context(), func() (int, error) {
+		ctx := req.Context()
+		if deadline, ok := p.Options.Retry.attemptDeadline(ctx); ok {
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+		}
+
+		var err error
+		if res, err = client.Do(req.WithContext(ctx)); err != nil {
+			cancel()
+			return 0, err
+		}
+		return res.StatusCode, nil
+	}, func() {
+		// drain so the connection can be reused
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxErrorMessage))
+		res.Body.Close()
+		cancel()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// retry calls attempt according to Options.Retry until it gets a response
+// that isn't worth retrying or runs out of attempts or time. attempt returns
+// the status code of the response it got, or an error; discard is called on a
+// response before it is retried. The last attempt's error is returned.
+func (p *Prerender) retry(ctx context.Context, attempt func() (int, error), discard func()) error {
+	rp := p.Options.Retry
+	if rp == nil || rp.MaxAttempts <= 1 {
+		_, err := attempt()
+		return err
+	}
+
+	start := time.Now()
+	retryDeadline, hasDeadline := ctx.Deadline()
+	if hasDeadline && rp.Budget > 0 {
+		retryDeadline = start.Add(time.Duration(float64(retryDeadline.Sub(start)) * rp.Budget))
+	}
+
+	for n := 1; ; n++ {
+		status, err := attempt()
+
+		retryable := false
+		if err != nil {
+			retryable = ctx.Err() == nil && retryableError(err)
+		} else {
+			retryable = rp.retryableStatus(status)
+		}
+
+		if !retryable || n >= rp.MaxAttempts {
+			return err
+		}
+
+		wait := rp.backoff(n)
+		if hasDeadline && time.Now().Add(wait).After(retryDeadline) {
+			return err
+		}
+
+		if err == nil {
+			discard()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package prerendercloud
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// retryPrerender returns a Prerender whose upstream answers each attempt with
+// the next status in statuses, where 0 stands for a network error.
+func retryPrerender(calls *int32, statuses ...int) *Prerender {
+	options := NewOptions()
+	options.Retry = &RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		Multiplier:           2,
+		RetryableStatusCodes: []int{502, 503, 504},
+	}
+	options.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		status := statuses[atomic.AddInt32(calls, 1)-1]
+		if status == 0 {
+			return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("attempt")),
+		}, nil
+	})}
+	return options.NewPrerender()
+}
+
+func Test_retryTransientStatus(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 503, 502, 200)
+
+	req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	res, err := p.fetch(p.httpClient(nil), req)
+	if err != nil || res.StatusCode != 200 {
+		t.Errorf("Error, expected a 200 after retrying, got %v %v", res, err)
+	}
+	if calls != 3 {
+		t.Errorf("Error, expected 3 attempts, got %d", calls)
+	}
+}
+
+func Test_retryNetworkError(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 0, 200)
+
+	req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	res, err := p.fetch(p.httpClient(nil), req)
+	if err != nil || res.StatusCode != 200 {
+		t.Errorf("Error, expected a 200 after retrying, got %v %v", res, err)
+	}
+	if calls != 2 {
+		t.Errorf("Error, expected 2 attempts, got %d", calls)
+	}
+}
+
+func Test_retrySkipsPermanentErrors(t *testing.T) {
+	errs := []error{
+		x509.UnknownAuthorityError{},
+		&net.DNSError{Err: "no such host", Name: "service.test", IsNotFound: true},
+		errors.New("unsupported protocol scheme"),
+	}
+
+	for _, permanent := range errs {
+		var calls int32
+		p := retryPrerender(&calls, 200)
+		p.Options.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, permanent
+		})
+
+		req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+		if _, err := p.fetch(p.httpClient(nil), req); !IsErrorKind(err, NetworkError) {
+			t.Errorf("Error, expected a NetworkError for %v, got %v", permanent, err)
+		}
+		if calls != 1 {
+			t.Errorf("Error, expected %v not to be retried, got %d attempts", permanent, calls)
+		}
+	}
+}
+
+func Test_retryAttemptTimeout(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 200)
+	p.Options.Retry.AttemptTimeout = 20 * time.Millisecond
+	p.Options.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("attempt"))}, nil
+	})
+
+	req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	res, err := p.fetch(p.httpClient(nil), req)
+	if err != nil || string(res.Body) != "attempt" {
+		t.Errorf("Error, expected the second attempt's response after the first timed out, got %v %v", res, err)
+	}
+	if calls != 2 {
+		t.Errorf("Error, expected 2 attempts, got %d", calls)
+	}
+}
+
+func Test_retryGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 503, 503, 503, 200)
+
+	req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	res, err := p.fetch(p.httpClient(nil), req)
+	if !IsErrorKind(err, UpstreamError) || res.StatusCode != 503 {
+		t.Errorf("Error, expected the last 503, got %v %v", res, err)
+	}
+	if calls != 3 {
+		t.Errorf("Error, expected 3 attempts, got %d", calls)
+	}
+}
+
+func Test_retrySkipsNonRetryableStatus(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 500, 200)
+
+	req, _ := http.NewRequest("GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	res, err := p.fetch(p.httpClient(nil), req)
+	if !IsErrorKind(err, UpstreamError) || res.StatusCode != 500 {
+		t.Errorf("Error, expected the 500 without retrying, got %v %v", res, err)
+	}
+	if calls != 1 {
+		t.Errorf("Error, expected 1 attempt, got %d", calls)
+	}
+}
+
+func Test_retryRespectsBudget(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 503, 200)
+	p.Options.Retry.InitialBackoff = time.Second
+	p.Options.Retry.Budget = 0.5
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	res, err := p.fetch(p.httpClient(nil), req)
+	if !IsErrorKind(err, UpstreamError) || res.StatusCode != 503 {
+		t.Errorf("Error, expected the 503 without retrying past the budget, got %v %v", res, err)
+	}
+	if calls != 1 {
+		t.Errorf("Error, expected 1 attempt, got %d", calls)
+	}
+}
+
+func Test_retryStopsWhenCanceled(t *testing.T) {
+	var calls int32
+	p := retryPrerender(&calls, 503, 200)
+	p.Options.Retry.InitialBackoff = time.Minute
+	p.Options.Timeout = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://service.headless-render-api.com/http://example.org/", nil)
+	_, err := p.fetch(p.httpClient(nil), req)
+	if !IsErrorKind(err, CanceledError) {
+		t.Errorf("Error, expected CanceledError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Error, expected 1 attempt, got %d", calls)
+	}
+}
+
+func Test_RetryPolicyBackoff(t *testing.T) {
+	rp := &RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i, wait := range expected {
+		if actual := rp.backoff(i + 1); actual != wait {
+			t.Errorf("Error, expected retry %d to wait %v, got %v", i+1, wait, actual)
+		}
+	}
+
+	rp.Jitter = 0.5
+	rp.random = func() float64 { return 0 }
+	if actual := rp.backoff(1); actual != 50*time.Millisecond {
+		t.Errorf("Error, expected jitter to halve the wait, got %v", actual)
+	}
+	rp.random = func() float64 { return 0.75 }
+	if actual := rp.backoff(1); actual != 125*time.Millisecond {
+		t.Errorf("Error, expected jitter to add a quarter, got %v", actual)
+	}
+}
+
+func Test_retryAPIClients(t *testing.T) {
+	var calls int32
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("png bytes"))
+	})
+	p.Options.Retry = DefaultRetryPolicy()
+	p.Options.Retry.InitialBackoff = time.Millisecond
+
+	image, err := p.Screenshot(context.Background(), "https://example.org/", nil)
+	if err != nil || string(image) != "png bytes" {
+		t.Errorf("Error, expected the screenshot after retrying, got %q %v", image, err)
+	}
+	if calls != 2 {
+		t.Errorf("Error, expected 2 attempts, got %d", calls)
+	}
+}
+
+func Test_retryBudgetUsesCallerDeadline(t *testing.T) {
+	var calls int32
+	statuses := make([]int, 100)
+	for i := range statuses {
+		statuses[i] = 503
+	}
+	p := retryPrerender(&calls, statuses...)
+	p.Options.Retry.MaxAttempts = len(statuses)
+	p.Options.Retry.InitialBackoff = 10 * time.Millisecond
+	p.Options.Retry.Multiplier = 1
+	p.Options.Retry.Budget = 0.5
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest("GET", "http://example.org/", nil).WithContext(ctx)
+	req.Header.Set("User-Agent", "twitterbot")
+
+	start := time.Now()
+	err := p.PreRender(httptest.NewRecorder(), req)
+	elapsed := time.Since(start)
+
+	if !IsErrorKind(err, UpstreamError) {
+		t.Errorf("Error, expected the last 503 once the budget ran out, got %v", err)
+	}
+	if elapsed > 250*time.Millisecond {
+		t.Errorf("Error, expected retries to stop after half of the 300ms deadline, took %v", elapsed)
+	}
+}
@@ -0,0 +1,103 @@
+package prerendercloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Route overrides Options for requests whose path matches Path, so one
+// Prerender can give different parts of an app different rendering behavior.
+type Route struct {
+	Path PathPattern
+
+	// Pattern, when set, matches the route pattern the request was routed
+	// by, such as "/users/{id}", instead of Path. The pattern is set by the
+	// chi and mux adapters, or by WithRoutePattern.
+	Pattern string
+
+	// Render, when non-nil, replaces Options.Render.
+	Render *RenderOptions
+
+	// BotsOnly, when non-nil, replaces Options.BotsOnly.
+	BotsOnly *bool
+
+	// Bots, when non-nil, replaces Options.Bots.
+	Bots *BotMatcher
+
+	// CacheTTL, when non-zero, is how long renders stay fresh in
+	// Options.Cache, overriding the upstream Cache-Control max-age.
+	CacheTTL time.Duration
+}
+
+// MatchRoute returns the first of Routes whose Path matches path. Routes with
+// a Pattern are skipped; see MatchRoutePattern.
+func (o *Options) MatchRoute(path string) (*Route, bool) {
+	return o.MatchRoutePattern(path, "")
+}
+
+// MatchRoutePattern returns the first of Routes whose Pattern is pattern, or
+// whose Path matches path when the route has no Pattern.
+func (o *Options) MatchRoutePattern(path, pattern string) (*Route, bool) {
+	for i := range o.Routes {
+		route := &o.Routes[i]
+		if route.Pattern != "" {
+			if route.Pattern == pattern {
+				return route, true
+			}
+			continue
+		}
+		if route.Path.Match(path) {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+func (p *Prerender) route(v requestView) *Route {
+	route, _ := p.Options.MatchRoutePattern(v.path(), v.routePattern())
+	return route
+}
+
+type routePatternKey struct{}
+
+// WithRoutePattern returns a copy of ctx carrying the route pattern the
+// request was routed by, for matching Route.Pattern. Router adapters call it
+// before handing the request to Prerender.
+func WithRoutePattern(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, routePatternKey{}, pattern)
+}
+
+// SetRoutePatternFastHttp is WithRoutePattern for fasthttp requests.
+func SetRoutePatternFastHttp(ctx *fasthttp.RequestCtx, pattern string) {
+	ctx.SetUserValue(routePatternKey{}, pattern)
+}
+
+// RoutePattern returns the route pattern set by WithRoutePattern or
+// SetRoutePatternFastHttp, or "" when there is none.
+func RoutePattern(ctx context.Context) string {
+	pattern, _ := ctx.Value(routePatternKey{}).(string)
+	return pattern
+}
+
+func (r *Route) botsOnly(o *Options) bool {
+	if r != nil && r.BotsOnly != nil {
+		return *r.BotsOnly
+	}
+	return o.BotsOnly
+}
+
+func (r *Route) renderOptions(o *Options) *RenderOptions {
+	if r != nil && r.Render != nil {
+		return r.Render
+	}
+	return o.Render
+}
+
+func (r *Route) cacheTTL() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.CacheTTL
+}
@@ -0,0 +1,144 @@
+package prerendercloud
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func routesPrerender() *Prerender {
+	botsOnly := true
+	options := NewOptions()
+	options.Render = &RenderOptions{DeviceWidth: 1280}
+	options.Routes = []Route{
+		{Path: Glob("/products/**"), Render: &RenderOptions{WaitExtraLong: true}, CacheTTL: time.Hour},
+		{Path: Glob("/blog/**"), Render: &RenderOptions{RemoveScriptTags: true}, BotsOnly: &botsOnly},
+		{Path: Glob("/share/**"), BotsOnly: &botsOnly, Bots: NewBotMatcher("mastodon")},
+	}
+	return options.NewPrerender()
+}
+
+func Test_MatchRoute(t *testing.T) {
+	p := routesPrerender()
+
+	if route, ok := p.Options.MatchRoute("/products/1"); !ok || route.Path.String() != "/products/**" {
+		t.Errorf("unexpected route %v", route)
+	}
+
+	if _, ok := p.Options.MatchRoute("/about"); ok {
+		t.Error("/about should not match a route")
+	}
+}
+
+func Test_routePattern(t *testing.T) {
+	options := NewOptions()
+	options.Routes = []Route{
+		{Pattern: "/users/{id}", CacheTTL: time.Hour},
+		{Path: Glob("/users/**"), CacheTTL: time.Minute},
+	}
+	p := options.NewPrerender()
+
+	c := conformanceCase{method: "GET", url: "http://www.example.com/users/42", headers: map[string]string{"User-Agent": "Twitterbot/1.0"}}
+
+	hv := c.httpRequest(t).(httpRequest)
+	if ttl := p.route(hv).cacheTTL(); ttl != time.Minute {
+		t.Errorf("without a route pattern the Path route should apply, got %v", ttl)
+	}
+
+	hv.r = hv.r.WithContext(WithRoutePattern(hv.r.Context(), "/users/{id}"))
+	if ttl := p.route(hv).cacheTTL(); ttl != time.Hour {
+		t.Errorf("the Pattern route should apply, got %v", ttl)
+	}
+
+	fv := c.fasthttpRequest(t).(fasthttpRequest)
+	SetRoutePatternFastHttp(fv.ctx, "/users/{id}")
+	if ttl := p.route(fv).cacheTTL(); ttl != time.Hour {
+		t.Errorf("the Pattern route should apply through fasthttp, got %v", ttl)
+	}
+}
+
+func Test_routeRenderOptions(t *testing.T) {
+	p := routesPrerender()
+
+	tests := []struct {
+		path   string
+		header string
+	}{
+		{"/products/1", "Prerender-Wait-Extra-Long"},
+		{"/blog/post", "Prerender-Remove-Script-Tags"},
+		{"/about", "Prerender-Device-Width"},
+	}
+
+	for _, test := range tests {
+		v := conformanceCase{method: "GET", url: "http://www.example.com" + test.path, headers: map[string]string{"User-Agent": "Twitterbot/1.0"}}.httpRequest(t)
+		req, err := p.newUpstreamRequest(context.Background(), v, p.route(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if req.Header.Get(test.header) == "" {
+			t.Errorf("%s: expected %s header, got %v", test.path, test.header, req.Header)
+		}
+
+		if test.path != "/about" && req.Header.Get("Prerender-Device-Width") != "" {
+			t.Errorf("%s: route render options should replace the global ones", test.path)
+		}
+	}
+}
+
+func Test_routeBotPolicy(t *testing.T) {
+	p := routesPrerender()
+
+	tests := []struct {
+		path      string
+		userAgent string
+		prerender bool
+	}{
+		{"/about", "Mozilla/5.0", true},
+		{"/blog/post", "Mozilla/5.0", false},
+		{"/blog/post", "Twitterbot/1.0", true},
+		{"/share/1", "Twitterbot/1.0", false},
+		{"/share/1", "Mastodon/4.2 (http.rb/5.1; +https://mastodon.social/)", true},
+	}
+
+	for _, test := range tests {
+		v := conformanceCase{method: "GET", url: "http://www.example.com" + test.path, headers: map[string]string{"User-Agent": test.userAgent}}.httpRequest(t)
+		if p.shouldPrerender(v) != test.prerender {
+			t.Errorf("%s with %q: shouldPrerender should be %v", test.path, test.userAgent, test.prerender)
+		}
+	}
+}
+
+func Test_routeCacheTTL(t *testing.T) {
+	options := NewOptions()
+	options.Cache = NewLRUCache(1024, time.Minute)
+	p := options.NewPrerender()
+
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=60")
+	p.store("key", &CachedResponse{StatusCode: 200, Header: header}, time.Hour)
+
+	cached, _ := options.Cache.Get("key")
+	if time.Until(cached.Expires) < 59*time.Minute {
+		t.Errorf("route CacheTTL should override max-age, expires %v", cached.Expires)
+	}
+}
+
+func Test_OptionsValidateRoutes(t *testing.T) {
+	options := NewOptions()
+	options.Routes = []Route{{Path: Glob("/a"), Render: &RenderOptions{DeviceHeight: -1}}}
+	if options.Validate() == nil {
+		t.Error("invalid route render options should fail validation")
+	}
+
+	options.Routes = []Route{{}}
+	if options.Validate() == nil {
+		t.Error("route without a path should fail validation")
+	}
+
+	options.Routes = []Route{{Pattern: "/users/{id}"}}
+	if err := options.Validate(); err != nil {
+		t.Errorf("route with only a pattern should pass validation, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package prerendercloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// ScreenshotOptions controls a Screenshot. Zero values leave the service
+// defaults in place.
+type ScreenshotOptions struct {
+	// ViewportWidth and ViewportHeight set the browser window size in pixels.
+	ViewportWidth  int
+	ViewportHeight int
+
+	// Format is "png", "jpeg" or "webp".
+	Format string
+
+	// Quality is the 1-100 compression quality for jpeg and webp.
+	Quality int
+
+	// FullPage captures the whole scrollable page rather than the viewport.
+	FullPage bool
+
+	// DeviceScale is the device pixel ratio, such as 2 for retina images.
+	DeviceScale float64
+}
+
+// Validate reports whether the options can be sent to the service.
+func (so *ScreenshotOptions) Validate() error {
+	if so.ViewportWidth < 0 || so.ViewportWidth > MaxDeviceSize {
+		return fmt.Errorf("prerendercloud: ViewportWidth %d out of range 0-%d", so.ViewportWidth, MaxDeviceSize)
+	}
+
+	if so.ViewportHeight < 0 || so.ViewportHeight > MaxDeviceSize {
+		return fmt.Errorf("prerendercloud: ViewportHeight %d out of range 0-%d", so.ViewportHeight, MaxDeviceSize)
+	}
+
+	switch so.Format {
+	case "", "png", "jpeg", "webp":
+	default:
+		return fmt.Errorf("prerendercloud: unsupported screenshot format %q", so.Format)
+	}
+
+	if so.Quality < 0 || so.Quality > 100 {
+		return fmt.Errorf("prerendercloud: Quality %d out of range 0-100", so.Quality)
+	}
+
+	if so.Quality > 0 && (so.Format == "" || so.Format == "png") {
+		return errors.New("prerendercloud: Quality only applies to jpeg and webp")
+	}
+
+	if so.DeviceScale < 0 || so.DeviceScale > 4 {
+		return fmt.Errorf("prerendercloud: DeviceScale %v out of range 0-4", so.DeviceScale)
+	}
+
+	return nil
+}
+
+func (so *ScreenshotOptions) setHeaders(header http.Header) {
+	if so.ViewportWidth > 0 {
+		header.Set("Prerender-Viewport-Width", strconv.Itoa(so.ViewportWidth))
+	}
+	if so.ViewportHeight > 0 {
+		header.Set("Prerender-Viewport-Height", strconv.Itoa(so.ViewportHeight))
+	}
+	if so.Format != "" {
+		header.Set("Prerender-Screenshot-Format", so.Format)
+	}
+	if so.Quality > 0 {
+		header.Set("Prerender-Screenshot-Quality", strconv.Itoa(so.Quality))
+	}
+	if so.FullPage {
+		header.Set("Prerender-Full-Page", "true")
+	}
+	if so.DeviceScale > 0 {
+		header.Set("Prerender-Viewport-Scale", strconv.FormatFloat(so.DeviceScale, 'f', -1, 64))
+	}
+}
+
+// Screenshot renders url with the service and returns the image. opts may be
+// nil. Failures are returned as an *Error; invalid opts as a RequestError.
+func (p *Prerender) Screenshot(ctx context.Context, url string, opts *ScreenshotOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &ScreenshotOptions{}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, &Error{Kind: RequestError, Err: err}
+	}
+
+	req, err := p.newAPIRequest(ctx, "screenshot", url)
+	if err != nil {
+		return nil, err
+	}
+	opts.setHeaders(req.Header)
+
+	res, err := p.doAPI(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, transportError(err, DecodeError)
+	}
+
+	return image, nil
+}
@@ -0,0 +1,97 @@
+package prerendercloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// apiStandIn starts an httptest server standing in for the service and
+// returns a Prerender pointed at it.
+func apiStandIn(t *testing.T, handler http.HandlerFunc) *Prerender {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	options := NewOptions()
+	options.PrerenderURL, _ = url.Parse(server.URL)
+	options.Token = "secret"
+	return options.NewPrerender()
+}
+
+func Test_Screenshot(t *testing.T) {
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/screenshot/https://example.org/page" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		expected := map[string]string{
+			"X-Prerender-Token":            "secret",
+			"Prerender-Viewport-Width":     "1280",
+			"Prerender-Viewport-Height":    "800",
+			"Prerender-Screenshot-Format":  "jpeg",
+			"Prerender-Screenshot-Quality": "80",
+			"Prerender-Full-Page":          "true",
+			"Prerender-Viewport-Scale":     "2",
+		}
+		for name, value := range expected {
+			if r.Header.Get(name) != value {
+				t.Errorf("expected %s: %s, got %q", name, value, r.Header.Get(name))
+			}
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg bytes"))
+	})
+
+	image, err := p.Screenshot(context.Background(), "https://example.org/page", &ScreenshotOptions{
+		ViewportWidth:  1280,
+		ViewportHeight: 800,
+		Format:         "jpeg",
+		Quality:        80,
+		FullPage:       true,
+		DeviceScale:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(image) != "jpeg bytes" {
+		t.Errorf("unexpected image %q", image)
+	}
+}
+
+func Test_ScreenshotErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		kind   ErrorKind
+	}{
+		{http.StatusUnauthorized, APIError},
+		{http.StatusBadRequest, APIError},
+		{http.StatusServiceUnavailable, UpstreamError},
+	}
+
+	for _, test := range tests {
+		p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", test.status)
+		})
+
+		_, err := p.Screenshot(context.Background(), "https://example.org/", nil)
+		if !IsErrorKind(err, test.kind) || err.(*Error).StatusCode != test.status {
+			t.Errorf("%d: expected %s, got %v", test.status, test.kind, err)
+		}
+	}
+
+	p := apiStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("invalid requests should not reach the service")
+	})
+
+	if _, err := p.Screenshot(context.Background(), "https://example.org/", &ScreenshotOptions{Format: "gif"}); !IsErrorKind(err, RequestError) {
+		t.Errorf("expected a RequestError for invalid options, got %v", err)
+	}
+
+	if _, err := p.Screenshot(context.Background(), "example.org", nil); !IsErrorKind(err, RequestError) {
+		t.Errorf("expected a RequestError for a relative url, got %v", err)
+	}
+}
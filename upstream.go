@@ -0,0 +1,196 @@
+package prerendercloud
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// upstream is a render request to the service, sent through net/http or
+// through fasthttp. render, coalesce and refresh work on either.
+type upstream interface {
+	// key identifies the rendering in Options.Cache and among in-flight
+	// renders. See upstreamKey.
+	key() string
+	context() context.Context
+	// detach returns a copy running with ctx that may outlive the incoming
+	// request. shared reports whether the incoming request still waits for
+	// the copy's result, as opposed to a background refresh. The copy's
+	// release must be called once it is done with.
+	detach(ctx context.Context, shared bool) upstream
+	release()
+	fetch() (*CachedResponse, error)
+}
+
+// httpUpstream is an upstream sent with net/http on behalf of the incoming
+// request or, which is nil when there is none, as in a background refresh.
+type httpUpstream struct {
+	p   *Prerender
+	or  *http.Request
+	req *http.Request
+}
+
+// upstreamKey keys a render of url. Renders that forward
+// OriginHeaderWhitelist headers may differ per user, so the forwarded values
+// are part of the key, hashed to keep credentials out of Options.Cache.
+func upstreamKey(url string, header func(name string) string) string {
+	names := header("Origin-Header-Whitelist")
+	if names == "" {
+		return url
+	}
+
+	h := sha256.New()
+	for _, name := range strings.Fields(names) {
+		h.Write([]byte(name + ": " + header(name) + "\n"))
+	}
+	return url + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+func (u *httpUpstream) key() string              { return upstreamKey(u.req.URL.String(), u.req.Header.Get) }
+func (u *httpUpstream) context() context.Context { return u.req.Context() }
+func (u *httpUpstream) release()                 {}
+
+func (u *httpUpstream) detach(ctx context.Context, shared bool) upstream {
+	or := u.or
+	if !shared {
+		or = nil
+	}
+	return &httpUpstream{p: u.p, or: or, req: u.req.Clone(ctx)}
+}
+
+// Do sends req, choosing the client again for every attempt. A
+// ClientFactory client may be bound to the incoming request, as the App
+// Engine one is, so it is only used while that request is being served;
+// otherwise, for instance once the first of several coalesced callers has
+// gone, HTTPClient is used, including to redo an attempt the incoming
+// request took down with it.
+func (u *httpUpstream) Do(req *http.Request) (*http.Response, error) {
+	if u.or != nil && u.or.Context().Err() == nil {
+		res, err := u.p.httpClient(u.or).Do(req)
+		if err == nil || u.or.Context().Err() == nil {
+			return res, err
+		}
+	}
+	return u.p.httpClient(nil).Do(req)
+}
+
+func (u *httpUpstream) fetch() (*CachedResponse, error) {
+	return u.p.fetch(u, u.req)
+}
+
+// NewFastHTTPClient returns a pooled fasthttp.Client like the one
+// PreRenderHandlerFastHttp uses by default. Clients built another way must set
+// DisablePathNormalizing, since the upstream path embeds the page's URL and
+// fasthttp would otherwise collapse its "//".
+func NewFastHTTPClient() *fasthttp.Client {
+	return &fasthttp.Client{
+		Name:                   userAgent,
+		DisablePathNormalizing: true,
+		MaxIdleConnDuration:    time.Minute,
+		ReadBufferSize:         16 << 10,
+	}
+}
+
+// fasthttpUpstream is an upstream sent with a fasthttp.Client, for
+// PreRenderHandlerFastHttp.
+type fasthttpUpstream struct {
+	p      *Prerender
+	client *fasthttp.Client
+	ctx    context.Context
+	url    string
+	req    *fasthttp.Request
+}
+
+// newFastHttpUpstream builds the request to the service on behalf of ctx,
+// which matched route (nil when no route matched).
+func (p *Prerender) newFastHttpUpstream(client *fasthttp.Client, ctx *fasthttp.RequestCtx, route *Route) *fasthttpUpstream {
+	v := fasthttpRequest{ctx}
+	u := &fasthttpUpstream{p: p, client: client, ctx: ctx, url: p.buildURL(v), req: fasthttp.AcquireRequest()}
+
+	u.req.SetRequestURI(u.url)
+	p.setUpstreamHeaders(v, route, &u.req.Header)
+	return u
+}
+
+func (u *fasthttpUpstream) key() string {
+	return upstreamKey(u.url, func(name string) string { return string(u.req.Header.Peek(name)) })
+}
+
+func (u *fasthttpUpstream) context() context.Context { return u.ctx }
+func (u *fasthttpUpstream) release()                 { fasthttp.ReleaseRequest(u.req) }
+
+// detach copies the request, which belongs to the incoming RequestCtx and is
+// released once the handler returns.
+func (u *fasthttpUpstream) detach(ctx context.Context, shared bool) upstream {
+	req := fasthttp.AcquireRequest()
+	u.req.CopyTo(req)
+	return &fasthttpUpstream{p: u.p, client: u.client, ctx: ctx, url: u.url, req: req}
+}
+
+// fetch is fetch and send for fasthttp: it performs the request the same
+// way, retrying according to Options.Retry within Options.Timeout, and reads
+// the whole response. fasthttp can't abandon a request in flight, so
+// cancelling the context only takes effect between attempts.
+func (u *fasthttpUpstream) fetch() (*CachedResponse, error) {
+	if !u.client.DisablePathNormalizing {
+		return nil, &Error{Kind: RequestError, Err: errors.New("FastHTTPClient must set DisablePathNormalizing")}
+	}
+
+	p, req := u.p, u.req
+	p.setServiceHeaders(&req.Header)
+
+	ctx, cancel, record, err := p.begin(u.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	err = p.retry(ctx, func() (int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		var err error
+		if deadline, ok := p.Options.Retry.attemptDeadline(ctx); ok {
+			err = u.client.DoDeadline(req, res, deadline)
+		} else {
+			err = u.client.Do(req, res)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return res.StatusCode(), nil
+	}, func() {})
+	if err != nil {
+		perr := transportError(err, NetworkError)
+		record(0, perr)
+		return nil, perr
+	}
+	record(res.StatusCode(), nil)
+
+	// copied, since res goes back to the pool
+	body, err := readBody(string(res.Header.ContentEncoding()), bytes.NewReader(res.Body()))
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	res.Header.VisitAll(func(key, value []byte) {
+		name := http.CanonicalHeaderKey(string(key))
+		if name != "Content-Encoding" && name != "Content-Length" {
+			header.Add(name, string(value))
+		}
+	})
+
+	return newCachedResponse(res.StatusCode(), header, body)
+}
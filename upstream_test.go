@@ -0,0 +1,319 @@
+package prerendercloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// fastStandIn starts an in-memory fasthttp server standing in for the service
+// and returns a Prerender pointed at it. FastHTTPClient and HTTPClient both
+// dial the server, so either path can be exercised.
+func fastStandIn(tb testing.TB, handler fasthttp.RequestHandler) *Prerender {
+	ln := fasthttputil.NewInmemoryListener()
+	go fasthttp.Serve(ln, handler)
+	tb.Cleanup(func() { ln.Close() })
+
+	options := NewOptions()
+	options.PrerenderURL, _ = url.Parse("http://service.test/")
+	options.Token = "secret"
+	options.FastHTTPClient = NewFastHTTPClient()
+	options.FastHTTPClient.Dial = func(addr string) (net.Conn, error) { return ln.Dial() }
+	options.HTTPClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) { return ln.Dial() },
+	}}
+	return options.NewPrerender()
+}
+
+// serveFastHttp runs PreRenderHandlerFastHttp for a GET of url.
+func serveFastHttp(p *Prerender, url, userAgent string) (*fasthttp.RequestCtx, error) {
+	var req fasthttp.Request
+	req.SetRequestURI(url)
+	req.Header.Set("User-Agent", userAgent)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&req, conformanceRemoteAddr, nil)
+	return ctx, p.PreRenderHandlerFastHttp(ctx)
+}
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.Bytes()
+}
+
+// Test_fasthttpUpstreamConformance checks that the native fasthttp request
+// carries the same URL and headers as the net/http one.
+func Test_fasthttpUpstreamConformance(t *testing.T) {
+	options := NewOptions()
+	options.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	options.Render = &RenderOptions{DeviceWidth: 1280, WaitExtraLong: true, OriginHeaderWhitelist: []string{"Content-Type", "X-Bufferbot"}}
+	options.FastHTTPClient = NewFastHTTPClient()
+	p := options.NewPrerender()
+
+	for _, c := range conformanceCases {
+		hv := c.httpRequest(t)
+		expected, err := p.newUpstreamRequest(context.Background(), hv, p.route(hv))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fv := c.fasthttpRequest(t).(fasthttpRequest)
+		u := p.newFastHttpUpstream(options.FastHTTPClient, fv.ctx, p.route(fv))
+
+		if u.url != expected.URL.String() {
+			t.Errorf("%s: net/http built %q, fasthttp built %q", c.name, expected.URL, u.url)
+		}
+
+		actual := http.Header{}
+		u.req.Header.VisitAll(func(key, value []byte) {
+			if string(key) != "Host" {
+				actual.Add(string(key), string(value))
+			}
+		})
+		if !reflect.DeepEqual(actual, expected.Header) {
+			t.Errorf("%s: net/http forwarded %v, fasthttp forwarded %v", c.name, expected.Header, actual)
+		}
+		if hu := (&httpUpstream{req: expected}); u.key() != hu.key() {
+			t.Errorf("%s: net/http keyed %q, fasthttp keyed %q", c.name, hu.key(), u.key())
+		}
+		u.release()
+	}
+}
+
+func Test_fasthttpUpstreamFetch(t *testing.T) {
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		expected := map[string]string{
+			"User-Agent":            "prerender-cloud-golang-middleware",
+			"X-Prerender-Token":     "secret",
+			"Accept-Encoding":       "gzip",
+			"X-Original-User-Agent": "Twitterbot/1.0",
+		}
+		for name, value := range expected {
+			if actual := string(ctx.Request.Header.Peek(name)); actual != value {
+				t.Errorf("expected %s: %s, got %q", name, value, actual)
+			}
+		}
+		if path := string(ctx.RequestURI()); path != "/http://www.example.com/page" {
+			t.Errorf("unexpected path %s", path)
+		}
+
+		ctx.SetStatusCode(201)
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.Response.Header.Set("Content-Encoding", "gzip")
+		ctx.Response.Header.Set("Cache-Control", "max-age=60")
+		ctx.SetBody(gzipped("prerendered response"))
+	})
+
+	ctx, err := serveFastHttp(p, "http://www.example.com/page", "Twitterbot/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.Response.StatusCode() != 201 || string(ctx.Response.Body()) != "prerendered response" {
+		t.Errorf("Error, expected the decoded response, got %d %q", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if contentType := string(ctx.Response.Header.ContentType()); contentType != "text/html; charset=utf-8" {
+		t.Errorf("Error, expected the upstream Content-Type, got %q", contentType)
+	}
+}
+
+func Test_fasthttpUpstreamResponseHeaders(t *testing.T) {
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("Content-Encoding", "gzip")
+		ctx.Response.Header.Set("Cache-Control", "max-age=60")
+		ctx.SetBody(gzipped("prerendered response"))
+	})
+
+	fv := conformanceCase{method: "GET", url: "http://www.example.com/", headers: map[string]string{"User-Agent": "Twitterbot/1.0"}}.fasthttpRequest(t).(fasthttpRequest)
+	u := p.newFastHttpUpstream(p.Options.FastHTTPClient, fv.ctx, nil)
+	defer u.release()
+
+	res, err := u.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(res.Body) != "prerendered response" {
+		t.Errorf("Error, expected the decoded body, got %q", res.Body)
+	}
+	if res.Header.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("Error, expected Cache-Control to be kept, got %v", res.Header)
+	}
+	if res.Header.Get("Content-Encoding") != "" || res.Header.Get("Content-Length") != "" {
+		t.Errorf("Error, expected Content-Encoding and Content-Length to be dropped, got %v", res.Header)
+	}
+}
+
+func Test_fasthttpUpstreamPathNormalizing(t *testing.T) {
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {})
+	p.Options.FastHTTPClient.DisablePathNormalizing = false
+
+	if p.Options.Validate() == nil {
+		t.Error("Error, a FastHTTPClient normalizing paths should fail validation")
+	}
+
+	_, err := serveFastHttp(p, "http://www.example.com/", "Twitterbot/1.0")
+	if !IsErrorKind(err, RequestError) {
+		t.Errorf("Error, expected a RequestError, got %v", err)
+	}
+}
+
+func Test_fasthttpUpstreamServerError(t *testing.T) {
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(503)
+		ctx.SetBodyString("server error")
+	})
+
+	ctx, err := serveFastHttp(p, "http://www.example.com/", "Twitterbot/1.0")
+	if !IsErrorKind(err, UpstreamError) {
+		t.Errorf("Error, expected an UpstreamError, got %v", err)
+	}
+	if len(ctx.Response.Body()) > 0 {
+		t.Error("Error, ctx should be untouched when the render fails")
+	}
+}
+
+func Test_fasthttpUpstreamNetworkError(t *testing.T) {
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {})
+	p.Options.FastHTTPClient.Dial = func(addr string) (net.Conn, error) { return nil, errors.New("connection refused") }
+
+	_, err := serveFastHttp(p, "http://www.example.com/", "Twitterbot/1.0")
+	if !IsErrorKind(err, NetworkError) {
+		t.Errorf("Error, expected a NetworkError, got %v", err)
+	}
+}
+
+func Test_fasthttpUpstreamTimeout(t *testing.T) {
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	p.Options.Timeout = 20 * time.Millisecond
+
+	_, err := serveFastHttp(p, "http://www.example.com/", "Twitterbot/1.0")
+	if !IsErrorKind(err, TimeoutError) {
+		t.Errorf("Error, expected a TimeoutError, got %v", err)
+	}
+}
+
+func Test_fasthttpUpstreamRetry(t *testing.T) {
+	var calls int32
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			ctx.SetStatusCode(503)
+			return
+		}
+		ctx.SetBodyString("prerendered response")
+	})
+	p.Options.Retry = DefaultRetryPolicy()
+	p.Options.Retry.InitialBackoff = time.Millisecond
+
+	ctx, err := serveFastHttp(p, "http://www.example.com/", "Twitterbot/1.0")
+	if err != nil || string(ctx.Response.Body()) != "prerendered response" {
+		t.Errorf("Error, expected the response after retrying, got %q %v", ctx.Response.Body(), err)
+	}
+	if calls != 2 {
+		t.Errorf("Error, expected 2 attempts, got %d", calls)
+	}
+}
+
+func Test_fasthttpUpstreamAttemptTimeout(t *testing.T) {
+	var calls int32
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		ctx.SetBodyString("prerendered response")
+	})
+	p.Options.Retry = DefaultRetryPolicy()
+	p.Options.Retry.InitialBackoff = time.Millisecond
+	p.Options.Retry.AttemptTimeout = 20 * time.Millisecond
+
+	ctx, err := serveFastHttp(p, "http://www.example.com/", "Twitterbot/1.0")
+	if err != nil || string(ctx.Response.Body()) != "prerendered response" {
+		t.Errorf("Error, expected the response after the first attempt timed out, got %q %v", ctx.Response.Body(), err)
+	}
+	if calls != 2 {
+		t.Errorf("Error, expected 2 attempts, got %d", calls)
+	}
+}
+
+func Test_fasthttpUpstreamCache(t *testing.T) {
+	var calls int32
+	p := fastStandIn(t, func(ctx *fasthttp.RequestCtx) {
+		atomic.AddInt32(&calls, 1)
+		ctx.SetBodyString("prerendered response")
+	})
+	p.Options.Cache = NewLRUCache(1<<20, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ctx, err := serveFastHttp(p, "http://www.example.com/cached", "Twitterbot/1.0")
+		if err != nil || string(ctx.Response.Body()) != "prerendered response" {
+			t.Errorf("Error, unexpected result %q %v", ctx.Response.Body(), err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Error, expected 1 upstream call, got %d", calls)
+	}
+}
+
+// BenchmarkPreRenderHandlerFastHttp compares PreRenderHandlerFastHttp with
+// PreRender serving the same render through net/http; run with -benchmem to
+// compare allocations per request.
+func BenchmarkPreRenderHandlerFastHttp(b *testing.B) {
+	body := gzipped("<html><body>prerendered response</body></html>")
+	standIn := func(b *testing.B) *Prerender {
+		return fastStandIn(b, func(ctx *fasthttp.RequestCtx) {
+			ctx.SetContentType("text/html")
+			ctx.Response.Header.Set("Content-Encoding", "gzip")
+			ctx.SetBody(body)
+		})
+	}
+
+	b.Run("net/http", func(b *testing.B) {
+		p := standIn(b)
+		req := httptest.NewRequest("GET", "http://www.example.com/page", nil)
+		req.Header.Set("User-Agent", "Twitterbot/1.0")
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := p.PreRender(httptest.NewRecorder(), req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("fasthttp", func(b *testing.B) {
+		p := standIn(b)
+		var req fasthttp.Request
+		req.SetRequestURI("http://www.example.com/page")
+		req.Header.Set("User-Agent", "Twitterbot/1.0")
+		var ctx fasthttp.RequestCtx
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ctx.Init(&req, conformanceRemoteAddr, nil)
+			if err := p.PreRenderHandlerFastHttp(&ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
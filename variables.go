@@ -4,6 +4,9 @@ import "regexp"
 
 var cfSchemeRegex = regexp.MustCompile("\"scheme\":\"(http|https)\"")
 
+// CrawlerUserAgents is the default bot list, used when Options.Bots is nil.
+// Changes made before NewPrerender is called are picked up; use
+// Options.AddBots and Options.RemoveBots to customize a single instance.
 var CrawlerUserAgents = []string{
 	// probably better to use _escaped_fragment_ rather
 	// than risk cloaking penalties for the big 3
@@ -44,3 +47,72 @@ var CrawlerUserAgents = []string{
 	"Discordbot",
 	"Google Page Speed",
 }
+
+// SocialPreviewBots fetch pages to build link previews in chat apps and
+// social networks.
+var SocialPreviewBots = []string{
+	"facebookexternalhit",
+	"facebookcatalog",
+	"twitterbot",
+	"linkedinbot",
+	"slackbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"skypeuripreview",
+	"vkshare",
+	"redditbot",
+	"pinterest",
+	"embedly",
+	"quora link preview",
+	"tumblr",
+	"flipboard",
+	"bitlybot",
+	"nuzzel",
+	"outbrain",
+	"showyoubot",
+	"mastodon",
+	"iframely",
+}
+
+// SearchEngineBots index pages for search results. BotsOnly leaves them out
+// by default to reduce the risk of cloaking penalties.
+var SearchEngineBots = []string{
+	"googlebot",
+	"bingbot",
+	"slurp",
+	"duckduckbot",
+	"baiduspider",
+	"yandexbot",
+	"applebot",
+	"seznambot",
+	"naver",
+}
+
+// SEOToolBots crawl pages on behalf of SEO and site auditing tools.
+var SEOToolBots = []string{
+	"rogerbot",
+	"ahrefsbot",
+	"semrushbot",
+	"mj12bot",
+	"dotbot",
+	"screaming frog",
+	"google page speed",
+	"chrome-lighthouse",
+	"w3c_validator",
+}
+
+// AICrawlerBots fetch pages for AI assistants and model training.
+var AICrawlerBots = []string{
+	"gptbot",
+	"chatgpt-user",
+	"oai-searchbot",
+	"claudebot",
+	"claude-user",
+	"perplexitybot",
+	"ccbot",
+	"bytespider",
+	"amazonbot",
+	"meta-externalagent",
+	"cohere-ai",
+}